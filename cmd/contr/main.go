@@ -1,23 +1,37 @@
 package main
 
 import (
-	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strconv"
+	"runtime"
 	"strings"
 	"syscall"
 	"time"
+
+	"github.com/sempr/hustoj-go-interactive/judgeio"
+	"github.com/sempr/hustoj-go-interactive/sandbox/caps"
+	"github.com/sempr/hustoj-go-interactive/sandbox/cgroup"
+	"github.com/sempr/hustoj-go-interactive/sandbox/monitor"
+	"github.com/sempr/hustoj-go-interactive/sandbox/ocibundle"
+	"github.com/sempr/hustoj-go-interactive/sandbox/rootfs"
+	"github.com/sempr/hustoj-go-interactive/sandbox/seccomp"
 )
 
+// sandboxRunDir holds the per-invocation overlay directories. It must be
+// writable by the controller and outlive no longer than one judging.
+const sandboxRunDir = "/var/run/hustoj-sandbox"
+
 // Result 表示 judge 向 controller 汇报的结果
 type Result struct {
-	Status string `json:"status"`
-	Reason string `json:"reason,omitempty"`
+	Status  string  `json:"status"`
+	Reason  string  `json:"reason,omitempty"`
+	Score   float64 `json:"score,omitempty"`
+	Details string  `json:"details,omitempty"`
 }
 
 // 最低权限 nobody
@@ -31,116 +45,36 @@ type SandboxConfig struct {
 	PlayerRootfs string
 	PlayerCmd    string
 	TimeoutMS    int
-}
-
-// helper
-func must(err error) {
-	if err != nil {
-		panic(err)
-		log.Fatal(err)
-	}
-}
 
-// CgroupStats holds resource usage statistics
-type CgroupStats struct {
-	MemoryPeakBytes uint64
-	CPUUsageUser    uint64
-	CPUUsageSystem  uint64
-}
+	// JudgeBundle/PlayerBundle, when set, point at an OCI runtime-spec
+	// bundle directory (config.json + rootfs/) whose process/root/mounts/
+	// linux.resources/linux.seccomp/linux.maskedPaths/linux.readonlyPaths
+	// override the flags above, letting problem images built for
+	// runc/crun/gVisor run under this controller unchanged.
+	JudgeBundle  string
+	PlayerBundle string
 
-// createCgroup creates a cgroup v2 directory and sets limits
-func createCgroup(name string, memoryLimitMB, cpuMax string) string {
-	cgroupPath := filepath.Join("/sys/fs/cgroup", name)
+	JudgeSeccompProfile  string
+	PlayerSeccompProfile string
 
-	must(os.MkdirAll(cgroupPath, 0755))
+	JudgePidsMax  string
+	PlayerPidsMax string
 
-	if memoryLimitMB != "" {
-		memFile := filepath.Join(cgroupPath, "memory.max")
-		must(os.WriteFile(memFile, []byte(memoryLimitMB+"M"), 0644))
-	}
-
-	if cpuMax != "" {
-		cpuFile := filepath.Join(cgroupPath, "cpu.max")
-		must(os.WriteFile(cpuFile, []byte(cpuMax), 0644))
-	}
+	// JudgeKeepCaps is a comma-separated list of CAP_* names that caps.Drop
+	// leaves in the judge sandbox's bounding set; everything else is
+	// dropped. The setuid to nobody that follows still clears the effective
+	// and permitted sets, so the exec'd judge holds none of them. The player
+	// sandbox never keeps any capability.
+	JudgeKeepCaps string
 
-	return cgroupPath
+	StatsPath string
 }
 
-// addProcessToCgroup adds a process to a cgroup
-func addProcessToCgroup(cgroupPath string, pid int) {
-	procsFile := filepath.Join(cgroupPath, "cgroup.procs")
-	err := os.WriteFile(procsFile, []byte(strconv.Itoa(pid)), 0644)
+// helper
+func must(err error) {
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "[CGROUP] Failed to add PID %d to cgroup %s: %v\n", pid, cgroupPath, err)
-	} else {
-		fmt.Fprintf(os.Stderr, "[CGROUP] Successfully added PID %d to cgroup %s\n", pid, cgroupPath)
-	}
-}
-
-// getCgroupStats reads resource usage from cgroup
-func getCgroupStats(cgroupPath string) CgroupStats {
-	stats := CgroupStats{}
-
-	if data, err := os.ReadFile(filepath.Join(cgroupPath, "memory.peak")); err == nil {
-		peakStr := strings.TrimSpace(string(data))
-		fmt.Fprintf(os.Stderr, "[CGROUP] memory.peak: %s\n", peakStr)
-		fmt.Sscanf(peakStr, "%d", &stats.MemoryPeakBytes)
-	} else {
-		fmt.Fprintf(os.Stderr, "[CGROUP] Failed to read memory.peak: %v\n", err)
-	}
-
-	if data, err := os.ReadFile(filepath.Join(cgroupPath, "memory.current")); err == nil {
-		currentStr := strings.TrimSpace(string(data))
-		fmt.Fprintf(os.Stderr, "[CGROUP] memory.current: %s\n", currentStr)
-	}
-
-	if data, err := os.ReadFile(filepath.Join(cgroupPath, "memory.stat")); err == nil {
-		lines := strings.Split(string(data), "\n")
-		for _, line := range lines {
-			fields := strings.Fields(line)
-			if len(fields) == 2 {
-				if val, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
-					if fields[0] == "file" && val > stats.MemoryPeakBytes {
-						stats.MemoryPeakBytes = val
-					}
-					if fields[0] == "anon" && val > stats.MemoryPeakBytes {
-						stats.MemoryPeakBytes = val
-					}
-					if fields[0] == "rss" && val > stats.MemoryPeakBytes {
-						stats.MemoryPeakBytes = val
-					}
-					if fields[0] == "shmem" && val > stats.MemoryPeakBytes {
-						stats.MemoryPeakBytes = val
-					}
-				}
-			}
-		}
-		fmt.Fprintf(os.Stderr, "[CGROUP] memory.stat total from rss/anon/file/shmem: %d bytes\n", stats.MemoryPeakBytes)
-	}
-
-	if data, err := os.ReadFile(filepath.Join(cgroupPath, "cpu.stat")); err == nil {
-		fmt.Fprintf(os.Stderr, "[CGROUP] cpu.stat: %s\n", string(data))
-		lines := strings.Split(string(data), "\n")
-		for _, line := range lines {
-			fields := strings.Fields(line)
-			if len(fields) == 2 {
-				switch fields[0] {
-				case "user_usec":
-					stats.CPUUsageUser, _ = strconv.ParseUint(fields[1], 10, 64)
-				case "system_usec":
-					stats.CPUUsageSystem, _ = strconv.ParseUint(fields[1], 10, 64)
-				}
-			}
-		}
+		panic(err)
 	}
-
-	return stats
-}
-
-// deleteCgroup removes a cgroup directory
-func deleteCgroup(cgroupPath string) {
-	_ = os.RemoveAll(cgroupPath)
 }
 
 // 解析 CLI 参数
@@ -150,42 +84,92 @@ func parseArgs() SandboxConfig {
 	flag.StringVar(&cfg.JudgeCmd, "judge-cmd", "/bin/judge", "judge command path")
 	flag.StringVar(&cfg.PlayerRootfs, "player-rootfs", "", "player rootfs path")
 	flag.StringVar(&cfg.PlayerCmd, "player-cmd", "/bin/player", "player command path")
+	flag.StringVar(&cfg.JudgeBundle, "judge-bundle", "", "OCI bundle directory (config.json + rootfs/) overriding judge-rootfs/judge-cmd and friends")
+	flag.StringVar(&cfg.PlayerBundle, "player-bundle", "", "OCI bundle directory (config.json + rootfs/) overriding player-rootfs/player-cmd and friends")
 	flag.IntVar(&cfg.TimeoutMS, "timeout", 5000, "timeout in milliseconds")
+	flag.StringVar(&cfg.JudgeSeccompProfile, "judge-seccomp-profile", "", "OCI-style seccomp profile JSON for the judge sandbox (default: built-in allow-list)")
+	flag.StringVar(&cfg.PlayerSeccompProfile, "player-seccomp-profile", "", "OCI-style seccomp profile JSON for the player sandbox (default: built-in allow-list)")
+	flag.StringVar(&cfg.JudgePidsMax, "judge-pids-max", "64", "max number of tasks/processes allowed in the judge cgroup")
+	flag.StringVar(&cfg.PlayerPidsMax, "player-pids-max", "64", "max number of tasks/processes allowed in the player cgroup")
+	flag.StringVar(&cfg.JudgeKeepCaps, "judge-keep-caps", "", "comma-separated CAP_* names left in the judge sandbox's bounding set; setuid still clears its effective/permitted sets (default: drop everything)")
+	flag.StringVar(&cfg.StatsPath, "stats-path", "stats.json", "where to write final peak resource usage, for the outer HustOJ pipeline")
 	flag.Parse()
 
-	if cfg.JudgeRootfs == "" || cfg.PlayerRootfs == "" {
-		log.Fatal("must provide rootfs paths")
+	if (cfg.JudgeRootfs == "" && cfg.JudgeBundle == "") || (cfg.PlayerRootfs == "" && cfg.PlayerBundle == "") {
+		log.Fatal("must provide rootfs paths, or --judge-bundle/--player-bundle")
 	}
 	return cfg
 }
 
-// 在子进程中执行 pivot_root + mount /proc + setuid
-func childInit(rootfs string) {
+// applyBundle loads dir as an OCI bundle and overrides the rootfs/cmd
+// fields of cfg (for "judge" or "player") from its config.json, returning
+// the loaded bundle so main can also pull resources/seccomp/mounts out of
+// it when spawning the sandbox.
+func applyBundle(cfg *SandboxConfig, which, dir string) *ocibundle.Bundle {
+	bundle, err := ocibundle.Load(dir)
+	must(err)
+
+	bundleRootfs, cmd := bundle.Rootfs(), ""
+	if argv := bundle.Argv(); len(argv) > 0 {
+		cmd = argv[0]
+	}
+	switch which {
+	case "judge":
+		cfg.JudgeRootfs = bundleRootfs
+		if cmd != "" {
+			cfg.JudgeCmd = cmd
+		}
+	case "player":
+		cfg.PlayerRootfs = bundleRootfs
+		if cmd != "" {
+			cfg.PlayerCmd = cmd
+		}
+	}
+	return bundle
+}
+
+// childSpec carries the OCI-bundle-derived child-process parameters that
+// don't fit the existing single-value SANDBOX_* env vars: argv, extra
+// env, rlimits, extra mounts and masked/readonly paths. It crosses the
+// re-exec boundary as JSON in SANDBOX_CHILD_SPEC rather than one
+// SANDBOX_* var per field. A zero childSpec reproduces the pre-bundle
+// behaviour exactly: exec target with no extra env, mounts or rlimits.
+type childSpec struct {
+	Argv          []string           `json:"argv,omitempty"`
+	Env           []string           `json:"env,omitempty"`
+	Rlimits       []ocibundle.Rlimit `json:"rlimits,omitempty"`
+	Mounts        []ocibundle.Mount  `json:"mounts,omitempty"`
+	MaskedPaths   []string           `json:"maskedPaths,omitempty"`
+	ReadonlyPaths []string           `json:"readonlyPaths,omitempty"`
+}
+
+// 在子进程中执行 overlay mount + pivot_root + mount /proc + setuid + seccomp
+func childInit(ov *rootfs.Overlay, seccompProfile seccomp.Profile, keepCaps []uintptr, spec childSpec) {
 	fmt.Fprintf(os.Stderr, "[SANDBOX] === childInit STARTED ===\n")
 	fmt.Fprintf(os.Stderr, "[SANDBOX] PID: %d, PPID: %d\n", os.Getpid(), os.Getppid())
 	fmt.Fprintf(os.Stderr, "[SANDBOX] UID: %d, GID: %d\n", os.Getuid(), os.Getgid())
-	fmt.Fprintf(os.Stderr, "[SANDBOX] Rootfs: %s\n", rootfs)
+	fmt.Fprintf(os.Stderr, "[SANDBOX] Rootfs (lower): %s, merged: %s\n", ov.Lower, ov.Merged)
 
 	// mount namespace 私有化
 	fmt.Fprintf(os.Stderr, "[SANDBOX] Creating private mount namespace...\n")
 	must(syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""))
 	fmt.Fprintf(os.Stderr, "[SANDBOX] Private mount namespace created\n")
 
-	// bind mount rootfs
-	fmt.Fprintf(os.Stderr, "[SANDBOX] Bind mounting rootfs: %s\n", rootfs)
-	must(syscall.Mount(rootfs, rootfs, "", syscall.MS_BIND|syscall.MS_REC, ""))
-	fmt.Fprintf(os.Stderr, "[SANDBOX] Rootfs bind mounted\n")
+	// 挂载 overlayfs: lowerdir 保持只读，所有写入都落到本次调用独占的 upperdir
+	fmt.Fprintf(os.Stderr, "[SANDBOX] Mounting overlay at %s...\n", ov.Merged)
+	must(ov.Mount())
+	fmt.Fprintf(os.Stderr, "[SANDBOX] Overlay mounted\n")
 
-	os.Mkdir(rootfs+"/proc", 0755)
+	must(os.Mkdir(filepath.Join(ov.Merged, "proc"), 0755))
 	// 创建 old_root 目录用于 pivot_root
-	oldRoot := rootfs + "/old_root"
+	oldRoot := filepath.Join(ov.Merged, "old_root")
 	fmt.Fprintf(os.Stderr, "[SANDBOX] Creating old_root directory: %s\n", oldRoot)
 	must(os.Mkdir(oldRoot, 0755))
 	fmt.Fprintf(os.Stderr, "[SANDBOX] old_root directory created\n")
 
-	// pivot_root
+	// pivot_root 进入 overlay 的 merged 视图，rootfs/lowerdir 本身不受影响
 	fmt.Fprintf(os.Stderr, "[SANDBOX] Executing pivot_root...\n")
-	must(syscall.PivotRoot(rootfs, oldRoot))
+	must(syscall.PivotRoot(ov.Merged, oldRoot))
 	fmt.Fprintf(os.Stderr, "[SANDBOX] pivot_root completed\n")
 
 	fmt.Fprintf(os.Stderr, "[SANDBOX] Changing directory to /...\n")
@@ -198,21 +182,82 @@ func childInit(rootfs string) {
 	_ = os.RemoveAll("/old_root")
 	fmt.Fprintf(os.Stderr, "[SANDBOX] old_root unmounted and removed\n")
 
-	// 挂载 /proc (使用 bind mount，必须在切换到 nobody 之前执行)
-	fmt.Fprintf(os.Stderr, "[SANDBOX] Mounting /proc via bind...\n")
-	err := syscall.Mount("/proc", "/proc", "", syscall.MS_BIND|syscall.MS_REC|syscall.MS_NOSUID|syscall.MS_NOEXEC|syscall.MS_NODEV, "")
+	// 挂载全新的 /proc (而非 bind host /proc，避免在 PID namespace 内仍能看到宿主机 PID 信息)
+	fmt.Fprintf(os.Stderr, "[SANDBOX] Mounting fresh /proc...\n")
+	err := syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NOEXEC|syscall.MS_NODEV, "")
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "[SANDBOX] Failed to mount /proc: %v\n", err)
 	} else {
 		fmt.Fprintf(os.Stderr, "[SANDBOX] /proc mounted successfully\n")
 	}
 
+	// 额外挂载点 (OCI bundle 的 mounts，按顺序全部挂载，其中的 proc/dev 条目也不跳过)
+	for _, m := range spec.Mounts {
+		if err := os.MkdirAll(m.Destination, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "[SANDBOX] mkdir mount destination %s: %v\n", m.Destination, err)
+			continue
+		}
+		if err := syscall.Mount(m.Source, m.Destination, m.Type, 0, strings.Join(m.Options, ",")); err != nil {
+			fmt.Fprintf(os.Stderr, "[SANDBOX] mount %s at %s: %v\n", m.Source, m.Destination, err)
+		}
+	}
+
+	// linux.maskedPaths: bind-mount /dev/null over each, hiding e.g.
+	// /proc/kcore from a process that can otherwise see a live /proc.
+	for _, p := range spec.MaskedPaths {
+		if err := syscall.Mount("/dev/null", p, "", syscall.MS_BIND, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "[SANDBOX] mask %s: %v\n", p, err)
+		}
+	}
+
+	// linux.readonlyPaths: bind-mount each path onto itself, then remount
+	// read-only (a single MS_BIND|MS_RDONLY mount ignores MS_RDONLY).
+	for _, p := range spec.ReadonlyPaths {
+		if err := syscall.Mount(p, p, "", syscall.MS_BIND, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "[SANDBOX] bind %s read-only: %v\n", p, err)
+			continue
+		}
+		if err := syscall.Mount(p, p, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "[SANDBOX] remount %s read-only: %v\n", p, err)
+		}
+	}
+
+	// process.rlimits
+	for _, rl := range spec.Rlimits {
+		resource, ok := rl.Resource()
+		if !ok {
+			fmt.Fprintf(os.Stderr, "[SANDBOX] unsupported rlimit type %q, skipping\n", rl.Type)
+			continue
+		}
+		if err := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: rl.Soft, Max: rl.Hard}); err != nil {
+			fmt.Fprintf(os.Stderr, "[SANDBOX] setrlimit %s: %v\n", rl.Type, err)
+		}
+	}
+
+	// 丢弃除 keepCaps 外的全部 capability (必须在 setuid 之前完成：一旦
+	// setuid 离开 uid 0，内核的 cap_emulate_setxuid 会在我们还没来得及
+	// 调用 PR_CAPBSET_DROP/capset 之前就把 effective/permitted 清零，
+	// 此时线程已不再拥有 CAP_SETPCAP，Drop 只会返回 EPERM)
+	fmt.Fprintf(os.Stderr, "[SANDBOX] Dropping capabilities (keep=%v)...\n", keepCaps)
+	must(caps.Drop(keepCaps))
+	fmt.Fprintf(os.Stderr, "[SANDBOX] Capabilities dropped\n")
+
 	// 切换到 nobody (在 user namespace 中已经是非特权)
 	fmt.Fprintf(os.Stderr, "[SANDBOX] Switching to nobody (UID=%d, GID=%d)...\n", nobodyUID, nobodyGID)
+	must(syscall.Setgroups(nil))
 	must(syscall.Setgid(nobodyGID))
 	must(syscall.Setuid(nobodyUID))
 	fmt.Fprintf(os.Stderr, "[SANDBOX] UID/GID switched to nobody\n")
 	fmt.Fprintf(os.Stderr, "[SANDBOX] Final UID: %d, GID: %d\n", os.Getuid(), os.Getgid())
+	caps.LogObservedMasks()
+
+	// 禁止通过 exec 重新获得特权 (必须在 setuid 之后、seccomp 安装之前)
+	must(caps.SetNoNewPrivs())
+
+	// 安装 seccomp-bpf 过滤器 (必须在 setuid 之后、exec 之前)
+	fmt.Fprintf(os.Stderr, "[SANDBOX] Installing seccomp filter...\n")
+	must(seccomp.Apply(seccompProfile))
+	fmt.Fprintf(os.Stderr, "[SANDBOX] seccomp filter installed\n")
 
 	fmt.Fprintf(os.Stderr, "[SANDBOX] === childInit COMPLETED ===\n")
 }
@@ -227,47 +272,190 @@ func maybeSandboxInit() {
 	}
 
 	fmt.Fprintf(os.Stderr, "[CHECK] Detected sandbox environment!\n")
-	rootfs := os.Getenv("SANDBOX_ROOTFS")
+	// caps.Drop and seccomp.Apply act on the calling OS thread only, so
+	// keep this goroutine on one thread through syscall.Exec.
+	runtime.LockOSThread()
 	target := os.Getenv("SANDBOX_TARGET")
-	fmt.Fprintf(os.Stderr, "[CHECK] SANDBOX_ROOTFS=%s\n", rootfs)
+	ov := &rootfs.Overlay{
+		Lower:  os.Getenv("SANDBOX_OVERLAY_LOWER"),
+		Upper:  os.Getenv("SANDBOX_OVERLAY_UPPER"),
+		Work:   os.Getenv("SANDBOX_OVERLAY_WORK"),
+		Merged: os.Getenv("SANDBOX_OVERLAY_MERGED"),
+	}
+	fmt.Fprintf(os.Stderr, "[CHECK] Overlay: %+v\n", ov)
 	fmt.Fprintf(os.Stderr, "[CHECK] SANDBOX_TARGET=%s\n", target)
 
-	if rootfs == "" {
-		panic("SANDBOX_ROOTFS missing")
+	if ov.Lower == "" || ov.Upper == "" || ov.Work == "" || ov.Merged == "" {
+		panic("SANDBOX_OVERLAY_* missing")
 	}
 
 	if target == "" {
 		panic("SANDBOX_TARGET missing")
 	}
 
+	seccompKind := os.Getenv("SANDBOX_SECCOMP_KIND")
+	seccompProfilePath := os.Getenv("SANDBOX_SECCOMP_PROFILE")
+	seccompProfile, err := resolveSeccompProfile(seccompKind, seccompProfilePath)
+	must(err)
+
+	keepCaps, err := caps.ParseKeepList(os.Getenv("SANDBOX_KEEP_CAPS"))
+	must(err)
+
+	var spec childSpec
+	if raw := os.Getenv("SANDBOX_CHILD_SPEC"); raw != "" {
+		must(json.Unmarshal([]byte(raw), &spec))
+	}
+
 	fmt.Fprintf(os.Stderr, "[CHECK] About to call childInit...\n")
-	childInit(rootfs)
+	childInit(ov, seccompProfile, keepCaps, spec)
 	fmt.Fprintf(os.Stderr, "[CHECK] childInit returned, sandbox setup complete\n")
 
 	fmt.Fprintf(os.Stderr, "[CHECK] Preparing to exec into target: %s\n", target)
 
 	// Clean environment before exec
 	os.Unsetenv("SANDBOX_INIT")
-	os.Unsetenv("SANDBOX_ROOTFS")
+	os.Unsetenv("SANDBOX_OVERLAY_LOWER")
+	os.Unsetenv("SANDBOX_OVERLAY_UPPER")
+	os.Unsetenv("SANDBOX_OVERLAY_WORK")
+	os.Unsetenv("SANDBOX_OVERLAY_MERGED")
 	os.Unsetenv("SANDBOX_TARGET")
+	os.Unsetenv("SANDBOX_SECCOMP_KIND")
+	os.Unsetenv("SANDBOX_SECCOMP_PROFILE")
+	os.Unsetenv("SANDBOX_KEEP_CAPS")
+	os.Unsetenv("SANDBOX_CHILD_SPEC")
 
 	fmt.Fprintf(os.Stderr, "[CHECK] Environment cleaned, calling syscall.Exec...\n")
 
+	// argv[0] is always the exec target itself; an OCI bundle's
+	// process.args[1:] become the rest (args[0] there is the bundle's own
+	// idea of argv[0], which we discard in favour of the real path).
+	argv := []string{target}
+	if len(spec.Argv) > 1 {
+		argv = append(argv, spec.Argv[1:]...)
+	}
+	env := append(os.Environ(), spec.Env...)
+
 	// syscall.Exec replaces this process with the target binary
 	// Process keeps all the sandbox setup (namespaces, mounts, UID/GID)
 	// but runs the target binary's code instead
-	must(syscall.Exec(target, []string{target}, os.Environ()))
+	must(syscall.Exec(target, argv, env))
 
 	// This line never reached because process is replaced
 	panic("syscall.Exec returned unexpectedly!")
 }
 
+// seccompWaitReason extracts a seccomp.ViolationReason from the error
+// returned by (*exec.Cmd).Wait, if the process was killed by its filter.
+func seccompWaitReason(waitErr error) string {
+	exitErr, ok := waitErr.(*exec.ExitError)
+	if !ok {
+		return ""
+	}
+	ws, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok {
+		return ""
+	}
+	return seccomp.ViolationReason(ws)
+}
+
+// writeStats dumps the final judge/player peak usage as stats.json, next
+// to the result, for the outer HustOJ pipeline to pick up.
+func writeStats(path string, judgeStats, playerStats cgroup.Stats) error {
+	data, err := json.MarshalIndent(struct {
+		Judge  cgroup.Stats `json:"judge"`
+		Player cgroup.Stats `json:"player"`
+	}{Judge: judgeStats, Player: playerStats}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// resolveSeccompProfile loads the profile named by profilePath, falling
+// back to the built-in judge/player allow-list when profilePath is empty.
+func resolveSeccompProfile(kind, profilePath string) (seccomp.Profile, error) {
+	if profilePath != "" {
+		return seccomp.LoadProfile(profilePath)
+	}
+	if kind == "judge" {
+		return seccomp.DefaultJudgeProfile(), nil
+	}
+	return seccomp.DefaultPlayerProfile(), nil
+}
+
+// writeSeccompProfileFile serializes an OCI-bundle-declared linux.seccomp
+// profile to a temp file, so it reaches the sandbox child through the same
+// SANDBOX_SECCOMP_PROFILE path mechanism --judge-seccomp-profile already
+// uses, instead of a second way of threading a seccomp.Profile across the
+// re-exec boundary.
+func writeSeccompProfileFile(p seccomp.Profile) (string, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", fmt.Errorf("writeSeccompProfileFile: marshal: %w", err)
+	}
+	f, err := os.CreateTemp("", "hustoj-seccomp-*.json")
+	if err != nil {
+		return "", fmt.Errorf("writeSeccompProfileFile: create temp file: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return "", fmt.Errorf("writeSeccompProfileFile: write: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// bundleSandboxParams pulls the resource limits, clone flags and childSpec
+// a loaded OCI bundle contributes to spawnSandbox, leaving cfg's existing
+// flag-derived values untouched wherever the bundle doesn't set something.
+func bundleSandboxParams(cfg *SandboxConfig, which string, bundle *ocibundle.Bundle, limits *cgroup.Limits, cloneFlags *uintptr) (childSpec, error) {
+	if v := bundle.MemoryLimitMB(); v != "" {
+		limits.MemoryLimitMB = v
+	}
+	if v := bundle.CPUMax(); v != "" {
+		limits.CPUMax = v
+	}
+	if v := bundle.PidsMax(); v != "" {
+		limits.PidsMax = v
+	}
+	*cloneFlags = bundle.CloneFlags()
+
+	if profile, ok := bundle.SeccompProfile(); ok {
+		path, err := writeSeccompProfileFile(profile)
+		if err != nil {
+			return childSpec{}, err
+		}
+		switch which {
+		case "judge":
+			cfg.JudgeSeccompProfile = path
+		case "player":
+			cfg.PlayerSeccompProfile = path
+		}
+	}
+
+	return childSpec{
+		Argv:          bundle.Argv(),
+		Env:           bundle.Env(),
+		Rlimits:       bundle.Rlimits(),
+		Mounts:        bundle.Spec.Mounts,
+		MaskedPaths:   bundle.MaskedPaths(),
+		ReadonlyPaths: bundle.ReadonlyPaths(),
+	}, nil
+}
+
 // spawnSandbox 创建一个命令在独立 namespace 下运行
-func spawnSandbox(cmdPath, rootfs string, cgroupPath string, stdin, stdout *os.File, extraFiles []*os.File) (*exec.Cmd, error) {
+func spawnSandbox(cmdPath, lowerRootfs string, mgr cgroup.Manager, stdin, stdout *os.File, extraFiles []*os.File, seccompKind, seccompProfilePath, keepCapsCSV string, cloneFlags uintptr, spec childSpec) (*exec.Cmd, *rootfs.Overlay, error) {
 	// Get path to this controller binary (we'll exec ourselves first)
 	selfPath, err := os.Executable()
 	must(err)
 
+	// Each invocation gets its own upperdir/workdir/merged, so concurrent
+	// judgings never race on the shared, read-only lowerRootfs image.
+	invocationID := fmt.Sprintf("%s-%d-%d", seccompKind, os.Getpid(), time.Now().UnixNano())
+	ov, err := rootfs.Prepare(sandboxRunDir, invocationID, lowerRootfs)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Start THIS binary with sandbox setup mode
 	cmd := exec.Command(selfPath)
 	cmd.Stdin = stdin
@@ -279,7 +467,7 @@ func spawnSandbox(cmdPath, rootfs string, cgroupPath string, stdin, stdout *os.F
 
 	// Create namespaces
 	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Cloneflags: syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUSER,
+		Cloneflags: cloneFlags,
 		UidMappings: []syscall.SysProcIDMap{
 			{ContainerID: 0, HostID: os.Getuid(), Size: 1},
 			{ContainerID: nobodyUID, HostID: nobodyUID, Size: 1},
@@ -290,29 +478,42 @@ func spawnSandbox(cmdPath, rootfs string, cgroupPath string, stdin, stdout *os.F
 		},
 	}
 
+	specJSON, err := json.Marshal(spec)
+	if err != nil {
+		return nil, nil, fmt.Errorf("spawnSandbox: marshal child spec: %w", err)
+	}
+
 	// Set environment to trigger sandbox setup mode
 	cmd.Env = append(os.Environ(),
 		"SANDBOX_INIT=1",
-		"SANDBOX_ROOTFS="+rootfs,
+		"SANDBOX_OVERLAY_LOWER="+ov.Lower,
+		"SANDBOX_OVERLAY_UPPER="+ov.Upper,
+		"SANDBOX_OVERLAY_WORK="+ov.Work,
+		"SANDBOX_OVERLAY_MERGED="+ov.Merged,
 		"SANDBOX_TARGET="+cmdPath,
+		"SANDBOX_SECCOMP_KIND="+seccompKind,
+		"SANDBOX_SECCOMP_PROFILE="+seccompProfilePath,
+		"SANDBOX_KEEP_CAPS="+keepCapsCSV,
+		"SANDBOX_CHILD_SPEC="+string(specJSON),
 	)
 	fmt.Fprintf(os.Stderr, "[SPAWN] Starting sandbox setup with controller binary\n")
-	fmt.Fprintf(os.Stderr, "[SPAWN] Environment: SANDBOX_INIT=1, ROOTFS=%s, TARGET=%s\n", rootfs, rootfs+cmdPath)
+	fmt.Fprintf(os.Stderr, "[SPAWN] Environment: SANDBOX_INIT=1, OVERLAY=%+v, TARGET=%s\n", ov, cmdPath)
 
 	err = cmd.Start()
+	if err != nil {
+		_ = ov.Cleanup()
+		return nil, nil, err
+	}
 	fmt.Fprintf(os.Stderr, "[SPAWN] Process started, PID: %d\n", cmd.Process.Pid)
 
-	if cgroupPath != "" {
-		fmt.Fprintf(os.Stderr, "[SPAWN] Adding PID %d to cgroup %s\n", cmd.Process.Pid, cgroupPath)
-		addProcessToCgroup(cgroupPath, cmd.Process.Pid)
-
-		// Verify the process was added
-		if procs, err := os.ReadFile(filepath.Join(cgroupPath, "cgroup.procs")); err == nil {
-			fmt.Fprintf(os.Stderr, "[SPAWN] cgroup.procs after add: %s", string(procs))
+	if mgr != nil {
+		fmt.Fprintf(os.Stderr, "[SPAWN] Adding PID %d to cgroup\n", cmd.Process.Pid)
+		if err := mgr.AddProc(cmd.Process.Pid); err != nil {
+			fmt.Fprintf(os.Stderr, "[SPAWN] Failed to add PID %d to cgroup: %v\n", cmd.Process.Pid, err)
 		}
 	}
 
-	return cmd, err
+	return cmd, ov, nil
 }
 
 func main() {
@@ -324,14 +525,42 @@ func main() {
 	cfg := parseArgs()
 	fmt.Fprintf(os.Stderr, "[MAIN] Parsed config, continuing as controller\n")
 
+	// Load OCI bundles, if any; this may override rootfs/cmd on cfg.
+	var judgeBundle, playerBundle *ocibundle.Bundle
+	if cfg.JudgeBundle != "" {
+		judgeBundle = applyBundle(&cfg, "judge", cfg.JudgeBundle)
+	}
+	if cfg.PlayerBundle != "" {
+		playerBundle = applyBundle(&cfg, "player", cfg.PlayerBundle)
+	}
+
+	judgeLimits := cgroup.Limits{MemoryLimitMB: "100", CPUMax: "100000 1000000", PidsMax: cfg.JudgePidsMax}
+	playerLimits := cgroup.Limits{MemoryLimitMB: "100", CPUMax: "100000 1000000", PidsMax: cfg.PlayerPidsMax}
+	const defaultCloneFlags = syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUSER
+	judgeCloneFlags, playerCloneFlags := uintptr(defaultCloneFlags), uintptr(defaultCloneFlags)
+	var judgeSpec, playerSpec childSpec
+	var err error
+	if judgeBundle != nil {
+		judgeSpec, err = bundleSandboxParams(&cfg, "judge", judgeBundle, &judgeLimits, &judgeCloneFlags)
+		must(err)
+	}
+	if playerBundle != nil {
+		playerSpec, err = bundleSandboxParams(&cfg, "player", playerBundle, &playerLimits, &playerCloneFlags)
+		must(err)
+	}
+
 	// Create cgroups
-	judgeCgroup := createCgroup("guess_judge", "100", "100000 1000000")
-	playerCgroup := createCgroup("guess_player", "100", "100000 1000000")
-	defer deleteCgroup(judgeCgroup)
-	defer deleteCgroup(playerCgroup)
+	judgeMgr, err := cgroup.New("guess_judge")
+	must(err)
+	must(judgeMgr.Create(judgeLimits))
+	defer judgeMgr.Destroy()
 
-	fmt.Fprintf(os.Stderr, "[CGROUP] Judge cgroup: %s\n", judgeCgroup)
-	fmt.Fprintf(os.Stderr, "[CGROUP] Player cgroup: %s\n", playerCgroup)
+	playerMgr, err := cgroup.New("guess_player")
+	must(err)
+	must(playerMgr.Create(playerLimits))
+	defer playerMgr.Destroy()
+
+	fmt.Fprintf(os.Stderr, "[CGROUP] Judge and player cgroups created\n")
 
 	// Judge -> Player pipes
 	jToP_R, jToP_W, _ := os.Pipe()
@@ -341,77 +570,121 @@ func main() {
 	reportR, reportW, _ := os.Pipe()
 
 	// spawn player
-	playerCmd, err := spawnSandbox(cfg.PlayerCmd, cfg.PlayerRootfs, playerCgroup, jToP_R, pToJ_W, nil)
+	playerCmd, playerOverlay, err := spawnSandbox(cfg.PlayerCmd, cfg.PlayerRootfs, playerMgr, jToP_R, pToJ_W, nil, "player", cfg.PlayerSeccompProfile, "", playerCloneFlags, playerSpec)
 	must(err)
+	defer playerOverlay.Cleanup()
 	// spawn judge
-	judgeCmd, err := spawnSandbox(cfg.JudgeCmd, cfg.JudgeRootfs, judgeCgroup, pToJ_R, jToP_W, []*os.File{reportW})
+	judgeCmd, judgeOverlay, err := spawnSandbox(cfg.JudgeCmd, cfg.JudgeRootfs, judgeMgr, pToJ_R, jToP_W, []*os.File{reportW}, "judge", cfg.JudgeSeccompProfile, cfg.JudgeKeepCaps, judgeCloneFlags, judgeSpec)
 	must(err)
+	defer judgeOverlay.Cleanup()
+	// The judge's copy of reportW (inherited via ExtraFiles) is the only
+	// one that needs to stay open; close ours so reportR sees EOF as soon
+	// as the judge exits, instead of staying open for the controller's
+	// own lifetime.
+	reportW.Close()
 
 	timeout := time.After(time.Duration(cfg.TimeoutMS) * time.Millisecond)
-	resultCh := make(chan string, 1)
+	resultCh := make(chan Result, 1)
+	oomCh := make(chan monitor.Result, 2)
+
+	eventLogger := monitor.LoggerFunc(func(e monitor.Event) {
+		fmt.Fprintf(os.Stderr, "[MONITOR-EVENT] cgroup=%s metric=%s value=%d threshold=%d\n", e.Cgroup, e.Metric, e.Value, e.Threshold)
+	})
+	judgeReporter := &monitor.Reporter{
+		Manager:               judgeMgr,
+		Cgroup:                "judge",
+		PollPeriod:            10 * time.Millisecond,
+		MemThresholds:         map[string][]uint64{"anon": {50 * 1024 * 1024, 90 * 1024 * 1024}},
+		CPUThrottleThresholds: []float64{0.5, 0.9},
+		Logger:                eventLogger,
+	}
+	playerReporter := &monitor.Reporter{
+		Manager:               playerMgr,
+		Cgroup:                "player",
+		PollPeriod:            10 * time.Millisecond,
+		MemThresholds:         map[string][]uint64{"anon": {50 * 1024 * 1024, 90 * 1024 * 1024}},
+		CPUThrottleThresholds: []float64{0.5, 0.9},
+		Logger:                eventLogger,
+	}
+	stopMonitor := make(chan struct{})
+	go judgeReporter.Run(stopMonitor, oomCh)
+	go playerReporter.Run(stopMonitor, oomCh)
 
-	// Monitor memory usage periodically
-	done := make(chan bool)
-	var maxJudgeMem, maxPlayerMem uint64
+	// 读取 judge 的 fd=3: progress/partial 转发给 stdout 供上层流式展示，
+	// final 才真正结束 resultCh；fd 关闭但没有 final 说明 judge 崩溃/被杀，
+	// 立即上报 RE，不再等待超时。
 	go func() {
-		ticker := time.NewTicker(10 * time.Millisecond)
-		defer ticker.Stop()
+		dec := judgeio.NewDecoder(reportR)
 		for {
-			select {
-			case <-done:
-				fmt.Fprintf(os.Stderr, "[MONITOR] Sampling stopped. maxJudgeMem=%d, maxPlayerMem=%d\n", maxJudgeMem, maxPlayerMem)
+			msg, err := dec.Next()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[JUDGEIO] fd closed without final: %v\n", err)
+				resultCh <- Result{Status: "RE", Reason: "judge exited without reporting a result"}
 				return
-			case <-ticker.C:
-				if data, err := os.ReadFile(filepath.Join(judgeCgroup, "memory.current")); err == nil {
-					var val uint64
-					fmt.Sscanf(string(data), "%d", &val)
-					if val > maxJudgeMem {
-						maxJudgeMem = val
-						fmt.Fprintf(os.Stderr, "[MONITOR] Judge memory.current: %d (new peak)\n", val)
-					}
-				}
-				if data, err := os.ReadFile(filepath.Join(playerCgroup, "memory.current")); err == nil {
-					var val uint64
-					fmt.Sscanf(string(data), "%d", &val)
-					if val > maxPlayerMem {
-						maxPlayerMem = val
-						fmt.Fprintf(os.Stderr, "[MONITOR] Player memory.current: %d (new peak)\n", val)
-					}
+			}
+			switch msg.Type {
+			case judgeio.TypeProgress, judgeio.TypePartial:
+				fmt.Println(string(msg.Payload))
+			case judgeio.TypeLog:
+				fmt.Fprintf(os.Stderr, "[JUDGE-LOG] %s\n", string(msg.Payload))
+			case judgeio.TypeFinal:
+				var fr judgeio.FinalResult
+				if err := json.Unmarshal(msg.Payload, &fr); err != nil {
+					fmt.Fprintf(os.Stderr, "[JUDGEIO] bad final payload: %v\n", err)
+					resultCh <- Result{Status: "RE", Reason: "judge sent an unparseable final result"}
+					return
 				}
+				resultCh <- Result{Status: fr.Status, Reason: fr.Reason, Score: fr.Score, Details: fr.Details}
+				return
+			default:
+				fmt.Fprintf(os.Stderr, "[JUDGEIO] unknown message type: %s\n", msg.Type)
 			}
 		}
 	}()
 
-	// 读取 judge 的 fd=3
-	go func() {
-		reader := bufio.NewReader(reportR)
-		line, _ := reader.ReadString('\n')
-		resultCh <- strings.TrimSpace(line)
-	}()
-
 	select {
 	case res := <-resultCh:
 		fmt.Println("[controller] result:", res)
+	case oomResult := <-oomCh:
+		fmt.Println("[controller] result:", Result{Status: oomResult.Status, Reason: oomResult.Reason})
+		_ = judgeCmd.Process.Kill()
+		_ = playerCmd.Process.Kill()
 	case <-timeout:
 		fmt.Println("[controller] timeout")
 		_ = judgeCmd.Process.Kill()
 		_ = playerCmd.Process.Kill()
 	}
 
-	judgeCmd.Wait()
-	playerCmd.Wait()
-	close(done)
+	judgeErr := judgeCmd.Wait()
+	playerErr := playerCmd.Wait()
+	if reason := seccompWaitReason(judgeErr); reason != "" {
+		fmt.Println("[controller] judge result:", Result{Status: "RE", Reason: reason})
+	}
+	if reason := seccompWaitReason(playerErr); reason != "" {
+		fmt.Println("[controller] player result:", Result{Status: "RE", Reason: reason})
+	}
+	close(stopMonitor)
 	time.Sleep(100 * time.Millisecond)
 
 	// Print cgroup statistics
-	judgeStats := getCgroupStats(judgeCgroup)
-	playerStats := getCgroupStats(playerCgroup)
+	judgeStats, err := judgeMgr.Stats()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[CGROUP] Failed to read judge stats: %v\n", err)
+	}
+	playerStats, err := playerMgr.Stats()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[CGROUP] Failed to read player stats: %v\n", err)
+	}
 
-	if maxJudgeMem > judgeStats.MemoryPeakBytes {
-		judgeStats.MemoryPeakBytes = maxJudgeMem
+	if judgeReporter.Peak > judgeStats.MemoryPeakBytes {
+		judgeStats.MemoryPeakBytes = judgeReporter.Peak
+	}
+	if playerReporter.Peak > playerStats.MemoryPeakBytes {
+		playerStats.MemoryPeakBytes = playerReporter.Peak
 	}
-	if maxPlayerMem > playerStats.MemoryPeakBytes {
-		playerStats.MemoryPeakBytes = maxPlayerMem
+
+	if err := writeStats(cfg.StatsPath, judgeStats, playerStats); err != nil {
+		fmt.Fprintf(os.Stderr, "[CGROUP] Failed to write stats to %s: %v\n", cfg.StatsPath, err)
 	}
 
 	fmt.Printf("\n[CGROUP STATS] Judge:\n")
@@ -421,20 +694,4 @@ func main() {
 	fmt.Printf("\n[CGROUP STATS] Player:\n")
 	fmt.Printf("  Memory Peak: %.2f MB\n", float64(playerStats.MemoryPeakBytes)/1024/1024)
 	fmt.Printf("  CPU Usage: user=%.2f ms, system=%.2f ms\n", float64(playerStats.CPUUsageUser)/1000, float64(playerStats.CPUUsageSystem)/1000)
-
-	// Debug: check cgroup.procs
-	if judgeProcs, err := os.ReadFile(filepath.Join(judgeCgroup, "cgroup.procs")); err == nil {
-		fmt.Fprintf(os.Stderr, "[CGROUP] Judge cgroup.procs: %s", string(judgeProcs))
-	}
-	if playerProcs, err := os.ReadFile(filepath.Join(playerCgroup, "cgroup.procs")); err == nil {
-		fmt.Fprintf(os.Stderr, "[CGROUP] Player cgroup.procs: %s", string(playerProcs))
-	}
-
-	// Debug: list cgroup files
-	files, _ := os.ReadDir(judgeCgroup)
-	fmt.Fprintf(os.Stderr, "[CGROUP] Judge cgroup files: ")
-	for _, f := range files {
-		fmt.Fprintf(os.Stderr, "%s ", f.Name())
-	}
-	fmt.Fprintf(os.Stderr, "\n")
 }
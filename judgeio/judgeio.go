@@ -0,0 +1,130 @@
+// Package judgeio implements the judge<->controller wire protocol used on
+// fd=3: newline-delimited JSON Messages carrying progress updates,
+// per-testcase partial scores and the terminal verdict. Judge binaries
+// written in Go should use Encoder instead of hand-rolling the framing.
+package judgeio
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// MessageType identifies the payload carried by a Message.
+type MessageType string
+
+const (
+	TypeProgress MessageType = "progress"
+	TypePartial  MessageType = "partial"
+	TypeFinal    MessageType = "final"
+	TypeLog      MessageType = "log"
+)
+
+// Message is one line of the fd=3 protocol: {"type": ..., "payload": ...}.
+type Message struct {
+	Type    MessageType     `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Progress reports how many testcases have been judged so far, for
+// multi-case interactive problems.
+type Progress struct {
+	CasesDone  int `json:"cases_done"`
+	CasesTotal int `json:"cases_total"`
+}
+
+// Partial carries the score for a single testcase as it completes.
+type Partial struct {
+	Case   int     `json:"case"`
+	Score  float64 `json:"score"`
+	Status string  `json:"status,omitempty"`
+}
+
+// FinalResult is the terminal verdict. It is the last message a judge
+// sends; the controller stops reading once it sees one.
+type FinalResult struct {
+	Status  string  `json:"status"`
+	Reason  string  `json:"reason,omitempty"`
+	Score   float64 `json:"score,omitempty"`
+	Details string  `json:"details,omitempty"`
+}
+
+// Encoder writes framed Messages to fd=3. It is safe for concurrent use.
+type Encoder struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewEncoder wraps w (typically os.NewFile(3, "judgeio")) for writing.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Progress sends a {cases_done, cases_total} progress update.
+func (e *Encoder) Progress(done, total int) error {
+	return e.encode(TypeProgress, Progress{CasesDone: done, CasesTotal: total})
+}
+
+// Partial sends a per-testcase score.
+func (e *Encoder) Partial(p Partial) error {
+	return e.encode(TypePartial, p)
+}
+
+// Log sends a free-text line for the controller to relay to its own logs.
+func (e *Encoder) Log(text string) error {
+	return e.encode(TypeLog, struct {
+		Text string `json:"text"`
+	}{Text: text})
+}
+
+// Final sends the terminal verdict. The controller stops reading after
+// this; callers should not write any further messages.
+func (e *Encoder) Final(r FinalResult) error {
+	return e.encode(TypeFinal, r)
+}
+
+func (e *Encoder) encode(t MessageType, payload interface{}) error {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("judgeio: marshal %s payload: %w", t, err)
+	}
+	line, err := json.Marshal(Message{Type: t, Payload: raw})
+	if err != nil {
+		return fmt.Errorf("judgeio: marshal message: %w", err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, err := e.w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("judgeio: write message: %w", err)
+	}
+	return nil
+}
+
+// Decoder reads framed Messages, one per line, from fd=3.
+type Decoder struct {
+	scanner *bufio.Scanner
+}
+
+// NewDecoder wraps r for reading.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{scanner: bufio.NewScanner(r)}
+}
+
+// Next blocks for the next Message, returning io.EOF once r is exhausted,
+// whether or not a final message was read before.
+func (d *Decoder) Next() (Message, error) {
+	if !d.scanner.Scan() {
+		if err := d.scanner.Err(); err != nil {
+			return Message{}, err
+		}
+		return Message{}, io.EOF
+	}
+	var msg Message
+	if err := json.Unmarshal(d.scanner.Bytes(), &msg); err != nil {
+		return Message{}, fmt.Errorf("judgeio: decode message: %w", err)
+	}
+	return msg, nil
+}
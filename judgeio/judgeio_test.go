@@ -0,0 +1,71 @@
+package judgeio
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+)
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+
+	if err := enc.Progress(1, 4); err != nil {
+		t.Fatalf("Progress: %v", err)
+	}
+	if err := enc.Partial(Partial{Case: 1, Score: 50, Status: "AC"}); err != nil {
+		t.Fatalf("Partial: %v", err)
+	}
+	if err := enc.Log("hello"); err != nil {
+		t.Fatalf("Log: %v", err)
+	}
+	if err := enc.Final(FinalResult{Status: "AC", Score: 100}); err != nil {
+		t.Fatalf("Final: %v", err)
+	}
+
+	dec := NewDecoder(&buf)
+
+	msg, err := dec.Next()
+	if err != nil || msg.Type != TypeProgress {
+		t.Fatalf("Next() #1 = %+v, %v, want type %q", msg, err, TypeProgress)
+	}
+	var p Progress
+	if err := json.Unmarshal(msg.Payload, &p); err != nil || p != (Progress{CasesDone: 1, CasesTotal: 4}) {
+		t.Fatalf("progress payload = %+v, %v", p, err)
+	}
+
+	msg, err = dec.Next()
+	if err != nil || msg.Type != TypePartial {
+		t.Fatalf("Next() #2 = %+v, %v, want type %q", msg, err, TypePartial)
+	}
+	var partial Partial
+	if err := json.Unmarshal(msg.Payload, &partial); err != nil || partial != (Partial{Case: 1, Score: 50, Status: "AC"}) {
+		t.Fatalf("partial payload = %+v, %v", partial, err)
+	}
+
+	msg, err = dec.Next()
+	if err != nil || msg.Type != TypeLog {
+		t.Fatalf("Next() #3 = %+v, %v, want type %q", msg, err, TypeLog)
+	}
+
+	msg, err = dec.Next()
+	if err != nil || msg.Type != TypeFinal {
+		t.Fatalf("Next() #4 = %+v, %v, want type %q", msg, err, TypeFinal)
+	}
+	var final FinalResult
+	if err := json.Unmarshal(msg.Payload, &final); err != nil || final != (FinalResult{Status: "AC", Score: 100}) {
+		t.Fatalf("final payload = %+v, %v", final, err)
+	}
+
+	if _, err := dec.Next(); err != io.EOF {
+		t.Fatalf("Next() after last message = %v, want io.EOF", err)
+	}
+}
+
+func TestDecoderRejectsMalformedLine(t *testing.T) {
+	dec := NewDecoder(bytes.NewBufferString("not json\n"))
+	if _, err := dec.Next(); err == nil {
+		t.Error("Next(): want error decoding a malformed line, got nil")
+	}
+}
@@ -0,0 +1,157 @@
+// Package caps drops Linux capabilities from the calling thread before a
+// sandboxed program is exec'd. Drop must run before setuid, while the
+// process still holds CAP_SETPCAP as root-in-userns: PR_CAPBSET_DROP and
+// capset themselves need that capability, and a setuid(2) away from uid 0
+// clears the calling thread's effective/permitted sets for free (the
+// cap_emulate_setxuid behaviour) since this package never sets
+// SECBIT_KEEP_CAPS. SetNoNewPrivs runs after setuid, and both run before
+// seccomp is installed, since PR_CAPBSET_DROP/capset/PR_SET_NO_NEW_PRIVS
+// themselves need privileged prctl/capset syscalls that a filter might
+// block.
+package caps
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	prCapbsetDrop      = 24
+	prSetNoNewPrivs    = 38
+	prCapAmbient       = 47
+	prCapAmbientClearAll = 4
+
+	sysPrctl  = 157
+	sysCapset = 126
+
+	// capLastCap is CAP_CHECKPOINT_RESTORE, the highest capability defined
+	// on the kernels this controller targets. Bump it if a newer kernel
+	// adds more; dropping a caller that is already unset is a no-op.
+	capLastCap = 40
+)
+
+// names maps the subset of capabilities judges are realistically allowed
+// to keep (via --judge-keep-caps) to their numeric value.
+var names = map[string]uintptr{
+	"CAP_CHOWN":              0,
+	"CAP_DAC_OVERRIDE":       1,
+	"CAP_DAC_READ_SEARCH":    2,
+	"CAP_FOWNER":             3,
+	"CAP_FSETID":             4,
+	"CAP_KILL":               5,
+	"CAP_SETGID":             6,
+	"CAP_SETUID":             7,
+	"CAP_NET_BIND_SERVICE":   10,
+	"CAP_NET_RAW":            13,
+	"CAP_SYS_CHROOT":         18,
+	"CAP_SYS_PTRACE":         19,
+	"CAP_SYS_ADMIN":          21,
+	"CAP_SYS_RESOURCE":       24,
+}
+
+// ParseKeepList turns a "--judge-keep-caps" comma-separated flag value into
+// capability numbers, erroring on unknown names rather than silently
+// granting nothing or everything.
+func ParseKeepList(csv string) ([]uintptr, error) {
+	if csv == "" {
+		return nil, nil
+	}
+	var keep []uintptr
+	for _, name := range strings.Split(csv, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		num, ok := names[name]
+		if !ok {
+			return nil, fmt.Errorf("caps: unknown capability %q", name)
+		}
+		keep = append(keep, num)
+	}
+	return keep, nil
+}
+
+// capUserHeader mirrors struct __user_cap_header_struct.
+type capUserHeader struct {
+	version uint32
+	pid     int32
+}
+
+// capUserData mirrors struct __user_cap_data_struct. Two entries cover
+// the 64 capability bits defined by the _LINUX_CAPABILITY_VERSION_3 ABI.
+type capUserData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+const linuxCapabilityVersion3 = 0x20080522
+
+// Drop clears the bounding, effective, permitted, inheritable and ambient
+// capability sets, leaving only the capabilities named in keep (normally
+// empty). Callers must invoke it while still root (before setuid/setgid):
+// PR_CAPBSET_DROP and capset both require CAP_SETPCAP, which a setuid away
+// from uid 0 revokes before clearing effective/permitted to 0 anyway.
+func Drop(keep []uintptr) error {
+	keepSet := make(map[uintptr]bool, len(keep))
+	for _, k := range keep {
+		keepSet[k] = true
+	}
+
+	for cap := uintptr(0); cap <= capLastCap; cap++ {
+		if keepSet[cap] {
+			continue
+		}
+		// EINVAL is expected for caps the running kernel doesn't know
+		// about (cap above its own CAP_LAST_CAP); ignore it.
+		if _, _, errno := syscall.Syscall6(sysPrctl, prCapbsetDrop, cap, 0, 0, 0, 0); errno != 0 && errno != syscall.EINVAL {
+			return fmt.Errorf("caps: PR_CAPBSET_DROP(%d): %w", cap, errno)
+		}
+	}
+
+	if _, _, errno := syscall.Syscall6(sysPrctl, prCapAmbient, prCapAmbientClearAll, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("caps: PR_CAP_AMBIENT_CLEAR_ALL: %w", errno)
+	}
+
+	var mask uint32
+	for k := range keepSet {
+		mask |= 1 << uint(k)
+	}
+	hdr := capUserHeader{version: linuxCapabilityVersion3, pid: 0}
+	data := [2]capUserData{
+		{effective: mask, permitted: mask, inheritable: 0},
+		{},
+	}
+	if _, _, errno := syscall.Syscall(sysCapset, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("caps: capset: %w", errno)
+	}
+
+	return nil
+}
+
+// SetNoNewPrivs sets PR_SET_NO_NEW_PRIVS, preventing the process (and its
+// children) from ever regaining privileges via setuid/setgid/file caps.
+func SetNoNewPrivs() error {
+	if _, _, errno := syscall.Syscall6(sysPrctl, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("caps: PR_SET_NO_NEW_PRIVS: %w", errno)
+	}
+	return nil
+}
+
+// LogObservedMasks prints CapEff/CapBnd from /proc/self/status to stderr,
+// so operators can confirm the drop actually took effect.
+func LogObservedMasks() {
+	data, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[CAPS] Failed to read /proc/self/status: %v\n", err)
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "CapEff:") || strings.HasPrefix(line, "CapBnd:") {
+			fmt.Fprintf(os.Stderr, "[CAPS] %s\n", line)
+		}
+	}
+}
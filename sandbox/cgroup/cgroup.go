@@ -0,0 +1,471 @@
+// Package cgroup abstracts over cgroup v1 and v2 so the controller can
+// apply memory/CPU/pids limits and read back usage statistics regardless
+// of which hierarchy the host kernel mounts.
+package cgroup
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Limits describes the resource caps to apply to a cgroup. Empty strings
+// mean "leave unlimited".
+type Limits struct {
+	MemoryLimitMB string
+	CPUMax        string // cgroup v2 "cpu.max" syntax, e.g. "100000 1000000"
+	PidsMax       string
+}
+
+// Stats holds resource usage read back from a cgroup.
+type Stats struct {
+	MemoryPeakBytes uint64 `json:"memory_peak_bytes"`
+	CPUUsageUser    uint64 `json:"cpu_usage_user_us"`
+	CPUUsageSystem  uint64 `json:"cpu_usage_system_us"`
+}
+
+// Manager creates, populates and tears down a single cgroup, hiding
+// whether the host runs v1 or v2.
+type Manager interface {
+	// Create makes the cgroup and applies limits.
+	Create(limits Limits) error
+	// AddProc moves pid into the cgroup.
+	AddProc(pid int) error
+	// Stats reads current usage.
+	Stats() (Stats, error)
+	// Destroy removes the cgroup. Safe to call on a cgroup that was
+	// never fully created.
+	Destroy() error
+	// Read returns the trimmed contents of a control file, e.g.
+	// Read("memory", "memory.stat"). controller is ignored on v2, where
+	// every control file lives under the single unified path.
+	Read(controller, file string) (string, error)
+
+	// MemorySample breaks current memory usage down the way monitor.Reporter
+	// needs for its per-category thresholds. v1's memory.stat doesn't track
+	// kernel/sock memory the way v2's does, so those two always come back 0
+	// there; anon/file map to v1's rss/cache, which is the closest existing
+	// equivalent.
+	MemorySample() (anon, file, kernel, sock uint64, err error)
+
+	// CPUThrottle returns cumulative CPU usage and CFS-throttled time, both
+	// in microseconds, so monitor.Reporter can compute a throttled fraction
+	// the same way on v1 and v2.
+	CPUThrottle() (usageUsec, throttledUsec uint64, err error)
+
+	// OOMKillCount returns the cumulative number of OOM kills in this
+	// cgroup. supported is false on v1, which has no equivalent of v2's
+	// memory.events:oom_kill counter; callers must not treat a false
+	// supported as "zero kills so far".
+	OOMKillCount() (count uint64, supported bool, err error)
+}
+
+// New detects the cgroup mode of the host (by inspecting
+// /proc/self/mountinfo) and returns a Manager for a cgroup named `name`.
+// It does not create the cgroup; call Create for that.
+func New(name string) (Manager, error) {
+	if unified() {
+		return &v2Manager{path: filepath.Join("/sys/fs/cgroup", name)}, nil
+	}
+	return newV1Manager(name)
+}
+
+// unified reports whether /sys/fs/cgroup is mounted as a single cgroup2
+// hierarchy, as opposed to the legacy v1 (or hybrid) per-controller mounts.
+func unified() bool {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		// Can't tell; assume v2 since it's the modern default.
+		return true
+	}
+	defer f.Close()
+	return parseUnified(f)
+}
+
+// parseUnified is unified's mountinfo-parsing half, split out so it can be
+// tested against fixture data instead of the live host's mounts.
+func parseUnified(r io.Reader) bool {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// mountinfo fields: ... mount-point ... - fstype source options
+		dashIdx := -1
+		for i, fld := range fields {
+			if fld == "-" {
+				dashIdx = i
+				break
+			}
+		}
+		if dashIdx < 0 || dashIdx+1 >= len(fields) {
+			continue
+		}
+		mountPoint := fields[4]
+		fsType := fields[dashIdx+1]
+		if mountPoint == "/sys/fs/cgroup" && fsType == "cgroup2" {
+			return true
+		}
+	}
+	return false
+}
+
+func writeLimit(path string, value string) error {
+	if value == "" {
+		return nil
+	}
+	return os.WriteFile(path, []byte(value), 0644)
+}
+
+func readUint(path string) uint64 {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	v, _ := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	return v
+}
+
+// parseStatField finds "key value" (one per line, as in memory.stat,
+// memory.events and cpu.stat on both cgroup versions) and returns value, or
+// 0 if key is absent.
+func parseStatField(stat, key string) uint64 {
+	for _, line := range strings.Split(stat, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == key {
+			v, _ := strconv.ParseUint(fields[1], 10, 64)
+			return v
+		}
+	}
+	return 0
+}
+
+// ---- cgroup v2 -------------------------------------------------------
+
+type v2Manager struct {
+	path string
+}
+
+func (m *v2Manager) Create(limits Limits) error {
+	if err := os.MkdirAll(m.path, 0755); err != nil {
+		return fmt.Errorf("cgroup: mkdir %s: %w", m.path, err)
+	}
+	if limits.MemoryLimitMB != "" {
+		if err := writeLimit(filepath.Join(m.path, "memory.max"), limits.MemoryLimitMB+"M"); err != nil {
+			return fmt.Errorf("cgroup: set memory.max: %w", err)
+		}
+	}
+	if limits.CPUMax != "" {
+		if err := writeLimit(filepath.Join(m.path, "cpu.max"), limits.CPUMax); err != nil {
+			return fmt.Errorf("cgroup: set cpu.max: %w", err)
+		}
+	}
+	if limits.PidsMax != "" {
+		if err := writeLimit(filepath.Join(m.path, "pids.max"), limits.PidsMax); err != nil {
+			return fmt.Errorf("cgroup: set pids.max: %w", err)
+		}
+	}
+	return nil
+}
+
+func (m *v2Manager) AddProc(pid int) error {
+	return os.WriteFile(filepath.Join(m.path, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644)
+}
+
+func (m *v2Manager) Stats() (Stats, error) {
+	s := Stats{MemoryPeakBytes: readUint(filepath.Join(m.path, "memory.peak"))}
+
+	if data, err := os.ReadFile(filepath.Join(m.path, "memory.stat")); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				continue
+			}
+			if fields[0] == "anon" || fields[0] == "file" {
+				if val, err := strconv.ParseUint(fields[1], 10, 64); err == nil && val > s.MemoryPeakBytes {
+					s.MemoryPeakBytes = val
+				}
+			}
+		}
+	}
+
+	if data, err := os.ReadFile(filepath.Join(m.path, "cpu.stat")); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				continue
+			}
+			switch fields[0] {
+			case "user_usec":
+				s.CPUUsageUser, _ = strconv.ParseUint(fields[1], 10, 64)
+			case "system_usec":
+				s.CPUUsageSystem, _ = strconv.ParseUint(fields[1], 10, 64)
+			}
+		}
+	}
+
+	return s, nil
+}
+
+func (m *v2Manager) Destroy() error {
+	return os.RemoveAll(m.path)
+}
+
+func (m *v2Manager) Read(_, file string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(m.path, file))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (m *v2Manager) MemorySample() (anon, file, kernel, sock uint64, err error) {
+	stat, err := m.Read("", "memory.stat")
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	return parseStatField(stat, "anon"), parseStatField(stat, "file"), parseStatField(stat, "kernel"), parseStatField(stat, "sock"), nil
+}
+
+func (m *v2Manager) CPUThrottle() (usageUsec, throttledUsec uint64, err error) {
+	stat, err := m.Read("", "cpu.stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	return parseStatField(stat, "usage_usec"), parseStatField(stat, "throttled_usec"), nil
+}
+
+func (m *v2Manager) OOMKillCount() (count uint64, supported bool, err error) {
+	events, err := m.Read("", "memory.events")
+	if err != nil {
+		return 0, true, err
+	}
+	return parseStatField(events, "oom_kill"), true, nil
+}
+
+// ---- cgroup v1 ---------------------------------------------------------
+
+// v1Manager drives the legacy per-controller hierarchies. Each controller
+// the process needs (memory, cpu, cpuacct, pids) lives under its own
+// mountpoint, so the cgroup for `name` is created once per controller.
+type v1Manager struct {
+	name        string
+	controllers map[string]string // controller -> mountpoint
+}
+
+func newV1Manager(name string) (*v1Manager, error) {
+	mounts, err := v1ControllerMounts()
+	if err != nil {
+		return nil, err
+	}
+	return &v1Manager{name: name, controllers: mounts}, nil
+}
+
+// v1ControllerMounts walks /proc/self/mountinfo for cgroup (v1) mounts and
+// returns a map of controller name (e.g. "memory", "cpu", "pids") to its
+// mountpoint.
+func v1ControllerMounts() (map[string]string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, fmt.Errorf("cgroup: open mountinfo: %w", err)
+	}
+	defer f.Close()
+	return parseV1ControllerMounts(f), nil
+}
+
+// parseV1ControllerMounts is v1ControllerMounts's mountinfo-parsing half,
+// split out so it can be tested against fixture data instead of the live
+// host's mounts.
+func parseV1ControllerMounts(r io.Reader) map[string]string {
+	mounts := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		dashIdx := -1
+		for i, fld := range fields {
+			if fld == "-" {
+				dashIdx = i
+				break
+			}
+		}
+		if dashIdx < 0 || dashIdx+2 >= len(fields) {
+			continue
+		}
+		if fields[dashIdx+1] != "cgroup" {
+			continue
+		}
+		mountPoint := fields[4]
+		opts := fields[dashIdx+3]
+		for _, opt := range strings.Split(opts, ",") {
+			switch opt {
+			case "memory", "cpu", "cpuacct", "pids":
+				mounts[opt] = mountPoint
+			}
+		}
+	}
+	return mounts
+}
+
+func (m *v1Manager) dir(controller string) (string, bool) {
+	root, ok := m.controllers[controller]
+	if !ok {
+		return "", false
+	}
+	return filepath.Join(root, m.name), true
+}
+
+func (m *v1Manager) Create(limits Limits) error {
+	for _, controller := range []string{"memory", "cpu", "cpuacct", "pids"} {
+		dir, ok := m.dir(controller)
+		if !ok {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("cgroup: mkdir %s: %w", dir, err)
+		}
+	}
+
+	if limits.MemoryLimitMB != "" {
+		if dir, ok := m.dir("memory"); ok {
+			mb, err := strconv.ParseUint(limits.MemoryLimitMB, 10, 64)
+			if err != nil {
+				return fmt.Errorf("cgroup: parse memory limit: %w", err)
+			}
+			limitBytes := strconv.FormatUint(mb*1024*1024, 10) // MB -> bytes, same 2^20 unit as v2's "M" suffix
+			if err := writeLimit(filepath.Join(dir, "memory.limit_in_bytes"), limitBytes); err != nil {
+				return fmt.Errorf("cgroup: set memory.limit_in_bytes: %w", err)
+			}
+			// memsw covers swap too; best-effort, not all kernels enable it.
+			_ = writeLimit(filepath.Join(dir, "memory.memsw.limit_in_bytes"), limitBytes)
+		}
+	}
+
+	if limits.CPUMax != "" {
+		if dir, ok := m.dir("cpu"); ok {
+			quota, period, err := splitCPUMax(limits.CPUMax)
+			if err != nil {
+				return fmt.Errorf("cgroup: parse cpu limit: %w", err)
+			}
+			if err := writeLimit(filepath.Join(dir, "cpu.cfs_period_us"), period); err != nil {
+				return fmt.Errorf("cgroup: set cpu.cfs_period_us: %w", err)
+			}
+			if err := writeLimit(filepath.Join(dir, "cpu.cfs_quota_us"), quota); err != nil {
+				return fmt.Errorf("cgroup: set cpu.cfs_quota_us: %w", err)
+			}
+		}
+	}
+
+	if limits.PidsMax != "" {
+		if dir, ok := m.dir("pids"); ok {
+			if err := writeLimit(filepath.Join(dir, "pids.limit"), limits.PidsMax); err != nil {
+				return fmt.Errorf("cgroup: set pids.limit: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// splitCPUMax turns cgroup v2's "cpu.max" syntax ("<quota> <period>") into
+// the pair of values cgroup v1 wants.
+func splitCPUMax(cpuMax string) (quota, period string, err error) {
+	fields := strings.Fields(cpuMax)
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("expected \"<quota> <period>\", got %q", cpuMax)
+	}
+	return fields[0], fields[1], nil
+}
+
+func (m *v1Manager) AddProc(pid int) error {
+	var firstErr error
+	for _, controller := range []string{"memory", "cpu", "cpuacct", "pids"} {
+		dir, ok := m.dir(controller)
+		if !ok {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (m *v1Manager) Stats() (Stats, error) {
+	s := Stats{}
+	if dir, ok := m.dir("memory"); ok {
+		s.MemoryPeakBytes = readUint(filepath.Join(dir, "memory.max_usage_in_bytes"))
+	}
+	if dir, ok := m.dir("cpuacct"); ok {
+		// cpuacct.usage is total ns; split evenly since v1 doesn't break
+		// out user/system the way cpu.stat does on v2.
+		total := readUint(filepath.Join(dir, "cpuacct.usage")) / 1000 // ns -> us
+		s.CPUUsageUser = total / 2
+		s.CPUUsageSystem = total - total/2
+	}
+	return s, nil
+}
+
+func (m *v1Manager) Read(controller, file string) (string, error) {
+	dir, ok := m.dir(controller)
+	if !ok {
+		return "", fmt.Errorf("cgroup: controller %q not mounted", controller)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// MemorySample maps v1's memory.stat keys onto the anon/file categories
+// Reporter thresholds against; rss is the closest v1 equivalent of v2's
+// anon, cache of v2's file. v1 has no per-cgroup breakdown of kernel or
+// socket memory comparable to v2's, so kernel and sock are always 0 here.
+func (m *v1Manager) MemorySample() (anon, file, kernel, sock uint64, err error) {
+	stat, err := m.Read("memory", "memory.stat")
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	return parseStatField(stat, "rss"), parseStatField(stat, "cache"), 0, 0, nil
+}
+
+// CPUThrottle reads usage from cpuacct.usage (ns, cpuacct controller) and
+// throttled time from cpu.stat's throttled_time (ns, cpu controller) —
+// v1 splits these across two mountpoints where v2 has both in one file.
+func (m *v1Manager) CPUThrottle() (usageUsec, throttledUsec uint64, err error) {
+	usage, err := m.Read("cpuacct", "cpuacct.usage")
+	if err != nil {
+		return 0, 0, err
+	}
+	usageNS, _ := strconv.ParseUint(strings.TrimSpace(usage), 10, 64)
+
+	stat, err := m.Read("cpu", "cpu.stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	return usageNS / 1000, parseStatField(stat, "throttled_time") / 1000, nil
+}
+
+// OOMKillCount always reports unsupported: v1's memory.oom_control only
+// exposes oom_kill_disable/under_oom, neither of which is a cumulative
+// kill counter like v2's memory.events:oom_kill, so there's nothing
+// equivalent to poll here.
+func (m *v1Manager) OOMKillCount() (count uint64, supported bool, err error) {
+	return 0, false, nil
+}
+
+func (m *v1Manager) Destroy() error {
+	var firstErr error
+	for _, controller := range []string{"memory", "cpu", "cpuacct", "pids"} {
+		dir, ok := m.dir(controller)
+		if !ok {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
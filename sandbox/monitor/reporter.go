@@ -0,0 +1,151 @@
+// Package monitor samples a cgroup's resource usage on a fixed period and
+// emits structured events when configured thresholds are crossed. It is
+// modeled after Arvados' crunchstat: a dumb poller that turns raw cgroup
+// control files into a small event stream, rather than a full metrics
+// pipeline.
+package monitor
+
+import (
+	"time"
+
+	"github.com/sempr/hustoj-go-interactive/sandbox/cgroup"
+)
+
+// Event is one threshold crossing.
+type Event struct {
+	Time      time.Time
+	Cgroup    string
+	Metric    string
+	Value     uint64
+	Threshold uint64
+}
+
+// Logger receives Events as the Reporter polls. Implementations must be
+// safe to call from the Reporter's own goroutine.
+type Logger interface {
+	Log(Event)
+}
+
+// LoggerFunc adapts a plain function to Logger.
+type LoggerFunc func(Event)
+
+func (f LoggerFunc) Log(e Event) { f(e) }
+
+// Reporter polls one cgroup and reports threshold crossings through Logger;
+// the running memory maximum is kept in Peak, not sent as an Event. It goes through Manager's MemorySample/CPUThrottle/
+// OOMKillCount rather than raw Read calls, so the same thresholds fire the
+// same way on cgroup v1 and v2; the one exception is the OOM fast path
+// below, which Manager reports as unsupported on v1.
+//
+// An OOM kill is additionally pushed to OOMCh so the controller can react
+// immediately instead of waiting for the sandboxed process's pipe to close.
+type Reporter struct {
+	Manager    cgroup.Manager
+	Cgroup     string // label used in emitted Events, e.g. "judge" or "player"
+	PollPeriod time.Duration
+
+	// MemThresholds maps a MemorySample category (anon, file, kernel,
+	// sock) to the byte thresholds that should each fire at most one
+	// Event. kernel and sock are always 0 on a cgroup v1 host, so
+	// thresholds set on them there will never fire.
+	MemThresholds map[string][]uint64
+	// CPUThrottleThresholds are fractions of cumulative throttled time over
+	// cumulative CPU usage (both as CPUThrottle reports them) that should
+	// each fire one Event.
+	CPUThrottleThresholds []float64
+
+	Logger Logger
+
+	// Peak is the highest total memory (anon+file+kernel+sock) observed
+	// across the Reporter's lifetime.
+	Peak uint64
+
+	firedMem    map[string]int // metric -> count of thresholds already fired
+	firedCPU    int
+	lastOOMKill uint64
+}
+
+// Run polls until stop is closed. oomCh receives a "MLE" Result as soon as
+// Manager.OOMKillCount increments (cgroup v2 only — see Reporter's doc
+// comment); callers should make it buffered by at least 1 so Run never
+// blocks on a slow reader.
+func (r *Reporter) Run(stop <-chan struct{}, oomCh chan<- Result) {
+	if r.firedMem == nil {
+		r.firedMem = make(map[string]int, len(r.MemThresholds))
+	}
+	ticker := time.NewTicker(r.PollPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			r.sample(oomCh)
+		}
+	}
+}
+
+// Result mirrors the controller's Result type without importing package
+// main (which would be a cycle); the controller converts it at the call
+// site.
+type Result struct {
+	Status string
+	Reason string
+}
+
+func (r *Reporter) sample(oomCh chan<- Result) {
+	now := time.Now()
+
+	if anon, file, kernel, sock, err := r.Manager.MemorySample(); err == nil {
+		r.checkMemThreshold(now, "anon", anon)
+		r.checkMemThreshold(now, "file", file)
+		r.checkMemThreshold(now, "kernel", kernel)
+		r.checkMemThreshold(now, "sock", sock)
+		if total := anon + file + kernel + sock; total > r.Peak {
+			r.Peak = total
+		}
+	}
+
+	// supported is false under cgroup v1, which has no persistent OOM-kill
+	// counter to poll; the fast path below simply never fires there, so
+	// this Reporter sends no MLE Result on a v1 host.
+	if count, supported, err := r.Manager.OOMKillCount(); supported && err == nil {
+		if count > r.lastOOMKill {
+			r.lastOOMKill = count
+			select {
+			case oomCh <- Result{Status: "MLE"}:
+			default:
+			}
+		}
+	}
+
+	if usage, throttled, err := r.Manager.CPUThrottle(); err == nil {
+		r.checkCPUThrottle(now, usage, throttled)
+	}
+}
+
+func (r *Reporter) checkMemThreshold(now time.Time, metric string, value uint64) {
+	thresholds := r.MemThresholds[metric]
+	for r.firedMem[metric] < len(thresholds) && value >= thresholds[r.firedMem[metric]] {
+		r.emit(Event{Time: now, Cgroup: r.Cgroup, Metric: "memory." + metric, Value: value, Threshold: thresholds[r.firedMem[metric]]})
+		r.firedMem[metric]++
+	}
+}
+
+func (r *Reporter) checkCPUThrottle(now time.Time, usage, throttled uint64) {
+	if usage == 0 {
+		return
+	}
+	fraction := float64(throttled) / float64(usage)
+	for r.firedCPU < len(r.CPUThrottleThresholds) && fraction >= r.CPUThrottleThresholds[r.firedCPU] {
+		r.emit(Event{Time: now, Cgroup: r.Cgroup, Metric: "cpu.throttled_fraction", Value: uint64(fraction * 1000), Threshold: uint64(r.CPUThrottleThresholds[r.firedCPU] * 1000)})
+		r.firedCPU++
+	}
+}
+
+func (r *Reporter) emit(e Event) {
+	if r.Logger != nil {
+		r.Logger.Log(e)
+	}
+}
@@ -0,0 +1,59 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckMemThresholdFiresEachOnce(t *testing.T) {
+	var events []Event
+	r := &Reporter{
+		Cgroup:        "judge",
+		MemThresholds: map[string][]uint64{"anon": {100, 200}},
+		Logger:        LoggerFunc(func(e Event) { events = append(events, e) }),
+		firedMem:      map[string]int{},
+	}
+	now := time.Unix(0, 0)
+
+	r.checkMemThreshold(now, "anon", 150)
+	if len(events) != 1 || events[0].Threshold != 100 {
+		t.Fatalf("after crossing first threshold: events = %+v", events)
+	}
+
+	// Sampling again below the next threshold must not re-fire the one
+	// already crossed.
+	r.checkMemThreshold(now, "anon", 150)
+	if len(events) != 1 {
+		t.Fatalf("threshold re-fired on a repeat sample: events = %+v", events)
+	}
+
+	r.checkMemThreshold(now, "anon", 250)
+	if len(events) != 2 || events[1].Threshold != 200 {
+		t.Fatalf("after crossing second threshold: events = %+v", events)
+	}
+}
+
+func TestCheckCPUThrottle(t *testing.T) {
+	var events []Event
+	r := &Reporter{
+		Cgroup:                "player",
+		CPUThrottleThresholds: []float64{0.5},
+		Logger:                LoggerFunc(func(e Event) { events = append(events, e) }),
+	}
+	now := time.Unix(0, 0)
+
+	r.checkCPUThrottle(now, 0, 0)
+	if len(events) != 0 {
+		t.Fatalf("zero usage must not divide by zero or fire: events = %+v", events)
+	}
+
+	r.checkCPUThrottle(now, 1000, 200)
+	if len(events) != 0 {
+		t.Fatalf("below threshold fired early: events = %+v", events)
+	}
+
+	r.checkCPUThrottle(now, 1000, 600)
+	if len(events) != 1 || events[0].Metric != "cpu.throttled_fraction" {
+		t.Fatalf("after crossing throttle threshold: events = %+v", events)
+	}
+}
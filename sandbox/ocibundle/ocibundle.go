@@ -0,0 +1,279 @@
+// Package ocibundle loads an OCI runtime-spec bundle (config.json plus a
+// rootfs/ directory) so the controller can reuse problem images built for
+// runc/crun/gVisor instead of the --judge-rootfs/--judge-cmd flags, acting
+// as a lightweight "interactive-judge runtime". Only the config.json
+// fields this controller understands are modeled; everything else is
+// ignored rather than rejected, since a bundle built for a full OCI
+// runtime will set plenty this controller has no use for.
+//
+// This is a hand-rolled mirror of the fields in
+// github.com/opencontainers/runtime-spec/specs-go, not that package itself
+// (go.mod has no external dependencies; every package in this module is
+// written against the standard library only). "Wire-compatible" only means
+// valid config.json input is accepted; any field this mirror doesn't
+// declare is silently dropped rather than validated against the real spec.
+package ocibundle
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/sempr/hustoj-go-interactive/sandbox/seccomp"
+)
+
+// Rlimit mirrors the OCI runtime-spec's POSIXRlimit.
+type Rlimit struct {
+	Type string `json:"type"`
+	Hard uint64 `json:"hard"`
+	Soft uint64 `json:"soft"`
+}
+
+// rlimitNPROC is RLIMIT_NPROC's setrlimit(2) resource number on Linux. The
+// standard syscall package doesn't define it for linux/amd64 (only
+// golang.org/x/sys/unix does, which this repo doesn't depend on), so it's
+// hardcoded here rather than pulling in that dependency for one constant.
+const rlimitNPROC = 6
+
+// rlimitResources maps POSIXRlimit.Type to the syscall.RLIMIT_* constant
+// setrlimit(2) expects. Only the limits a sandboxed judge/player could
+// plausibly need are listed; Resource reports the rest as unsupported
+// rather than guessing.
+var rlimitResources = map[string]int{
+	"RLIMIT_AS":     syscall.RLIMIT_AS,
+	"RLIMIT_CORE":   syscall.RLIMIT_CORE,
+	"RLIMIT_CPU":    syscall.RLIMIT_CPU,
+	"RLIMIT_DATA":   syscall.RLIMIT_DATA,
+	"RLIMIT_FSIZE":  syscall.RLIMIT_FSIZE,
+	"RLIMIT_NOFILE": syscall.RLIMIT_NOFILE,
+	"RLIMIT_NPROC":  rlimitNPROC,
+	"RLIMIT_STACK":  syscall.RLIMIT_STACK,
+}
+
+// Resource resolves r.Type to a setrlimit(2) resource number.
+func (r Rlimit) Resource() (int, bool) {
+	res, ok := rlimitResources[r.Type]
+	return res, ok
+}
+
+// Process mirrors the subset of the OCI runtime-spec's Process this
+// controller acts on.
+type Process struct {
+	Args    []string `json:"args,omitempty"`
+	Env     []string `json:"env,omitempty"`
+	Rlimits []Rlimit `json:"rlimits,omitempty"`
+}
+
+// Root mirrors the OCI runtime-spec's Root.
+type Root struct {
+	Path string `json:"path"`
+}
+
+// Mount mirrors the OCI runtime-spec's Mount.
+type Mount struct {
+	Destination string   `json:"destination"`
+	Source      string   `json:"source,omitempty"`
+	Type        string   `json:"type,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// Namespace mirrors the OCI runtime-spec's LinuxNamespace.
+type Namespace struct {
+	Type string `json:"type"`
+}
+
+// namespaceFlags maps LinuxNamespace.Type to the CLONE_NEW* flag it asks
+// for.
+var namespaceFlags = map[string]uintptr{
+	"mount":   syscall.CLONE_NEWNS,
+	"pid":     syscall.CLONE_NEWPID,
+	"uts":     syscall.CLONE_NEWUTS,
+	"ipc":     syscall.CLONE_NEWIPC,
+	"user":    syscall.CLONE_NEWUSER,
+	"network": syscall.CLONE_NEWNET,
+}
+
+// defaultCloneFlags is what spawnSandbox has always used for the judge and
+// player sandboxes; it's the fallback when a bundle doesn't declare
+// linux.namespaces at all.
+const defaultCloneFlags = syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUSER
+
+// Memory mirrors the OCI runtime-spec's LinuxMemory.
+type Memory struct {
+	Limit *int64 `json:"limit,omitempty"`
+}
+
+// CPU mirrors the OCI runtime-spec's LinuxCPU.
+type CPU struct {
+	Quota  *int64  `json:"quota,omitempty"`
+	Period *uint64 `json:"period,omitempty"`
+}
+
+// Pids mirrors the OCI runtime-spec's LinuxPids.
+type Pids struct {
+	Limit int64 `json:"limit"`
+}
+
+// Resources mirrors the OCI runtime-spec's LinuxResources.
+type Resources struct {
+	Memory *Memory `json:"memory,omitempty"`
+	CPU    *CPU    `json:"cpu,omitempty"`
+	Pids   *Pids   `json:"pids,omitempty"`
+}
+
+// Linux mirrors the OCI runtime-spec's Linux, restricted to the fields
+// this controller acts on. Seccomp reuses seccomp.Profile directly: its
+// JSON shape is already the LinuxSeccomp subset seccomp.LoadProfile
+// accepts.
+type Linux struct {
+	Namespaces    []Namespace      `json:"namespaces,omitempty"`
+	Resources     *Resources       `json:"resources,omitempty"`
+	Seccomp       *seccomp.Profile `json:"seccomp,omitempty"`
+	MaskedPaths   []string         `json:"maskedPaths,omitempty"`
+	ReadonlyPaths []string         `json:"readonlyPaths,omitempty"`
+}
+
+// Spec mirrors the top-level config.json of an OCI runtime-spec bundle.
+type Spec struct {
+	Process *Process `json:"process,omitempty"`
+	Root    *Root    `json:"root,omitempty"`
+	Mounts  []Mount  `json:"mounts,omitempty"`
+	Linux   *Linux   `json:"linux,omitempty"`
+}
+
+// Bundle is a loaded OCI bundle directory: its config.json plus the
+// rootfs it points at.
+type Bundle struct {
+	Dir  string
+	Spec Spec
+}
+
+// Load reads dir/config.json.
+func Load(dir string) (*Bundle, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return nil, fmt.Errorf("ocibundle: read config.json: %w", err)
+	}
+	var spec Spec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("ocibundle: parse config.json: %w", err)
+	}
+	return &Bundle{Dir: dir, Spec: spec}, nil
+}
+
+// Rootfs resolves Spec.Root.Path against Dir, defaulting to "rootfs" as
+// the OCI spec does when Root is unset.
+func (b *Bundle) Rootfs() string {
+	path := "rootfs"
+	if b.Spec.Root != nil && b.Spec.Root.Path != "" {
+		path = b.Spec.Root.Path
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(b.Dir, path)
+}
+
+// Argv returns Spec.Process.Args, or nil if the bundle doesn't set one.
+func (b *Bundle) Argv() []string {
+	if b.Spec.Process == nil {
+		return nil
+	}
+	return b.Spec.Process.Args
+}
+
+// Env returns Spec.Process.Env, or nil if the bundle doesn't set one.
+func (b *Bundle) Env() []string {
+	if b.Spec.Process == nil {
+		return nil
+	}
+	return b.Spec.Process.Env
+}
+
+// Rlimits returns Spec.Process.Rlimits, or nil if the bundle doesn't set
+// any.
+func (b *Bundle) Rlimits() []Rlimit {
+	if b.Spec.Process == nil {
+		return nil
+	}
+	return b.Spec.Process.Rlimits
+}
+
+// MaskedPaths returns Spec.Linux.MaskedPaths, or nil if unset.
+func (b *Bundle) MaskedPaths() []string {
+	if b.Spec.Linux == nil {
+		return nil
+	}
+	return b.Spec.Linux.MaskedPaths
+}
+
+// ReadonlyPaths returns Spec.Linux.ReadonlyPaths, or nil if unset.
+func (b *Bundle) ReadonlyPaths() []string {
+	if b.Spec.Linux == nil {
+		return nil
+	}
+	return b.Spec.Linux.ReadonlyPaths
+}
+
+// CloneFlags translates Spec.Linux.Namespaces into CLONE_NEW* flags,
+// falling back to defaultCloneFlags (the flags this controller has always
+// used) when the bundle doesn't declare any.
+func (b *Bundle) CloneFlags() uintptr {
+	if b.Spec.Linux == nil || len(b.Spec.Linux.Namespaces) == 0 {
+		return defaultCloneFlags
+	}
+	var flags uintptr
+	for _, ns := range b.Spec.Linux.Namespaces {
+		flags |= namespaceFlags[ns.Type]
+	}
+	return flags
+}
+
+// MemoryLimitMB converts Linux.Resources.Memory.Limit (bytes, as the OCI
+// spec has it) to the whole-megabyte string cgroup.Limits wants, or ""
+// if unset.
+func (b *Bundle) MemoryLimitMB() string {
+	m := b.resources()
+	if m == nil || m.Memory == nil || m.Memory.Limit == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d", *m.Memory.Limit/1024/1024)
+}
+
+// CPUMax converts Linux.Resources.CPU.{Quota,Period} to cgroup v2's
+// "cpu.max" syntax ("<quota> <period>"), or "" if either is unset.
+func (b *Bundle) CPUMax() string {
+	m := b.resources()
+	if m == nil || m.CPU == nil || m.CPU.Quota == nil || m.CPU.Period == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d %d", *m.CPU.Quota, *m.CPU.Period)
+}
+
+// PidsMax converts Linux.Resources.Pids.Limit to the string
+// cgroup.Limits wants, or "" if unset.
+func (b *Bundle) PidsMax() string {
+	m := b.resources()
+	if m == nil || m.Pids == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d", m.Pids.Limit)
+}
+
+func (b *Bundle) resources() *Resources {
+	if b.Spec.Linux == nil {
+		return nil
+	}
+	return b.Spec.Linux.Resources
+}
+
+// SeccompProfile returns the bundle's Linux.Seccomp profile and true, or
+// a zero Profile and false if the bundle doesn't set one.
+func (b *Bundle) SeccompProfile() (seccomp.Profile, bool) {
+	if b.Spec.Linux == nil || b.Spec.Linux.Seccomp == nil {
+		return seccomp.Profile{}, false
+	}
+	return *b.Spec.Linux.Seccomp, true
+}
@@ -0,0 +1,127 @@
+package ocibundle
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func writeBundle(t *testing.T, configJSON string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(configJSON), 0644); err != nil {
+		t.Fatalf("write config.json: %v", err)
+	}
+	return dir
+}
+
+func TestLoadAndRootfsDefaults(t *testing.T) {
+	dir := writeBundle(t, `{"process":{"args":["/judge"],"env":["FOO=bar"]}}`)
+
+	b, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got, want := b.Rootfs(), filepath.Join(dir, "rootfs"); got != want {
+		t.Errorf("Rootfs() = %q, want %q (default when root.path is unset)", got, want)
+	}
+	if got := b.Argv(); len(got) != 1 || got[0] != "/judge" {
+		t.Errorf("Argv() = %v, want [/judge]", got)
+	}
+	if got := b.Env(); len(got) != 1 || got[0] != "FOO=bar" {
+		t.Errorf("Env() = %v, want [FOO=bar]", got)
+	}
+	if got := b.CloneFlags(); got != uintptr(defaultCloneFlags) {
+		t.Errorf("CloneFlags() = %#x, want defaultCloneFlags %#x (no linux.namespaces set)", got, defaultCloneFlags)
+	}
+}
+
+func TestRootfsExplicitPath(t *testing.T) {
+	dir := writeBundle(t, `{"root":{"path":"/abs/rootfs"}}`)
+	b, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := b.Rootfs(); got != "/abs/rootfs" {
+		t.Errorf("Rootfs() = %q, want the absolute path unchanged", got)
+	}
+
+	dir = writeBundle(t, `{"root":{"path":"rel/rootfs"}}`)
+	b, err = Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got, want := b.Rootfs(), filepath.Join(dir, "rel/rootfs"); got != want {
+		t.Errorf("Rootfs() = %q, want %q (relative path joined with bundle dir)", got, want)
+	}
+}
+
+func TestCloneFlagsFromNamespaces(t *testing.T) {
+	dir := writeBundle(t, `{"linux":{"namespaces":[{"type":"pid"},{"type":"mount"}]}}`)
+	b, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	want := uintptr(syscall.CLONE_NEWPID | syscall.CLONE_NEWNS)
+	if got := b.CloneFlags(); got != want {
+		t.Errorf("CloneFlags() = %#x, want %#x", got, want)
+	}
+}
+
+func TestResourceAccessors(t *testing.T) {
+	dir := writeBundle(t, `{"linux":{"resources":{
+		"memory":{"limit":104857600},
+		"cpu":{"quota":100000,"period":1000000},
+		"pids":{"limit":32}
+	}}}`)
+	b, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := b.MemoryLimitMB(); got != "100" {
+		t.Errorf("MemoryLimitMB() = %q, want \"100\" (104857600 bytes)", got)
+	}
+	if got := b.CPUMax(); got != "100000 1000000" {
+		t.Errorf("CPUMax() = %q, want \"100000 1000000\"", got)
+	}
+	if got := b.PidsMax(); got != "32" {
+		t.Errorf("PidsMax() = %q, want \"32\"", got)
+	}
+}
+
+func TestResourceAccessorsUnset(t *testing.T) {
+	dir := writeBundle(t, `{}`)
+	b, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := b.MemoryLimitMB(); got != "" {
+		t.Errorf("MemoryLimitMB() = %q, want \"\" when linux.resources is unset", got)
+	}
+	if got := b.CPUMax(); got != "" {
+		t.Errorf("CPUMax() = %q, want \"\" when linux.resources is unset", got)
+	}
+	if got := b.PidsMax(); got != "" {
+		t.Errorf("PidsMax() = %q, want \"\" when linux.resources is unset", got)
+	}
+}
+
+func TestLoadMissingConfig(t *testing.T) {
+	if _, err := Load(t.TempDir()); err == nil {
+		t.Error("Load: want error when config.json is missing")
+	}
+}
+
+func TestRlimitResource(t *testing.T) {
+	r := Rlimit{Type: "RLIMIT_NPROC"}
+	res, ok := r.Resource()
+	if !ok || res != rlimitNPROC {
+		t.Errorf("Resource() = (%d, %v), want (%d, true)", res, ok, rlimitNPROC)
+	}
+
+	r = Rlimit{Type: "RLIMIT_BOGUS"}
+	if _, ok := r.Resource(); ok {
+		t.Error("Resource(): want ok=false for an unsupported rlimit type")
+	}
+}
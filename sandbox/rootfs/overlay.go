@@ -0,0 +1,57 @@
+// Package rootfs builds a per-invocation overlayfs rootfs for a sandbox,
+// so concurrent judgings never mutate the shared, read-only rootfs image
+// that --judge-rootfs/--player-rootfs point at.
+package rootfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Overlay describes the directories backing one overlayfs mount: Lower is
+// the caller-supplied, read-only rootfs image; Upper/Work/Merged are
+// scratch directories owned by this invocation alone.
+type Overlay struct {
+	Lower  string
+	Upper  string
+	Work   string
+	Merged string
+}
+
+// Prepare creates a fresh {upper,work,merged} triple for one sandbox
+// invocation under baseDir/<id>, leaving lower untouched. The caller picks
+// id (e.g. a per-judging UUID) so concurrent judgings never collide.
+func Prepare(baseDir, id, lower string) (*Overlay, error) {
+	root := filepath.Join(baseDir, id)
+	o := &Overlay{
+		Lower:  lower,
+		Upper:  filepath.Join(root, "upper"),
+		Work:   filepath.Join(root, "work"),
+		Merged: filepath.Join(root, "merged"),
+	}
+	for _, dir := range []string{o.Upper, o.Work, o.Merged} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("rootfs: mkdir %s: %w", dir, err)
+		}
+	}
+	return o, nil
+}
+
+// Mount mounts the overlayfs. It must run inside the sandbox's own mount
+// namespace (i.e. from childInit, after CLONE_NEWNS), before pivot_root.
+func (o *Overlay) Mount() error {
+	opts := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", o.Lower, o.Upper, o.Work)
+	if err := syscall.Mount("overlay", o.Merged, "overlay", 0, opts); err != nil {
+		return fmt.Errorf("rootfs: mount overlay at %s: %w", o.Merged, err)
+	}
+	return nil
+}
+
+// Cleanup removes the per-invocation upper/work/merged directories (and
+// their parent). It runs on the controller side, after Wait() returns, so
+// no state persists between judgings.
+func (o *Overlay) Cleanup() error {
+	return os.RemoveAll(filepath.Dir(o.Upper))
+}
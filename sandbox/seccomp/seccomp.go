@@ -0,0 +1,247 @@
+// Package seccomp installs seccomp-bpf syscall filters on the calling
+// thread. It is meant to be invoked from childInit, after the sandboxed
+// process has dropped to an unprivileged UID/GID and right before
+// syscall.Exec replaces it with the judge/player binary.
+package seccomp
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Linux uapi constants (asm-generic/prctl.h, linux/seccomp.h, linux/audit.h).
+const (
+	prSetNoNewPrivs = 38
+
+	secCompSetModeFilter = 1
+	secCompFilterFlagTSync = 0 // we only ever touch the calling thread
+
+	auditArchX86_64 = 0xC000003E
+
+	sysPrctl   = 157
+	sysSeccomp = 317
+)
+
+// classic BPF opcodes (linux/bpf_common.h), spelled out instead of pulling
+// in golang.org/x/sys/unix since this controller has no other dependency
+// on it.
+const (
+	bpfLd  = 0x00
+	bpfW   = 0x00
+	bpfAbs = 0x20
+	bpfJmp = 0x05
+	bpfJeq = 0x10
+	bpfK   = 0x00
+	bpfRet = 0x06
+)
+
+// SECCOMP_RET_* actions (linux/seccomp.h). The high 16 bits carry the
+// action, the low 16 bits carry the action data (e.g. the errno).
+const (
+	retKillProcess uint32 = 0x80000000
+	retErrno       uint32 = 0x00050000
+	retAllow       uint32 = 0x7fff0000
+)
+
+// Action is the action to take when a rule matches. It mirrors the OCI
+// runtime-spec LinuxSeccompAction strings so profiles loaded from JSON
+// can use the same vocabulary.
+type Action string
+
+const (
+	ActionAllow    Action = "SCMP_ACT_ALLOW"
+	ActionErrno    Action = "SCMP_ACT_ERRNO"
+	ActionKill     Action = "SCMP_ACT_KILL_PROCESS"
+)
+
+// SyscallRule matches one or more syscalls by name to an Action.
+type SyscallRule struct {
+	Names  []string `json:"names"`
+	Action Action   `json:"action"`
+	Errno  uint32   `json:"errnoRet,omitempty"`
+}
+
+// Profile is a minimal, wire-compatible subset of the OCI runtime-spec's
+// LinuxSeccomp: a DefaultAction applied to anything not matched by Syscalls.
+type Profile struct {
+	DefaultAction Action        `json:"defaultAction"`
+	Syscalls      []SyscallRule `json:"syscalls"`
+}
+
+// syscallNumbers maps syscall names to their x86-64 syscall numbers. Only
+// the syscalls referenced by the default profiles are listed; compile skips
+// any rule name missing from this table, so such a syscall falls through to
+// the profile's DefaultAction. LoadProfile does not check names.
+var syscallNumbers = map[string]uint32{
+	"read":          0,
+	"write":         1,
+	"close":         3,
+	"fstat":         5,
+	"poll":          7,
+	"mmap":          9,
+	"mprotect":      10,
+	"munmap":        11,
+	"brk":           12,
+	"rt_sigaction":  13,
+	"rt_sigprocmask": 14,
+	"rt_sigreturn":  15,
+	"pread64":       17,
+	"pwrite64":      18,
+	"sched_yield":   24,
+	"nanosleep":     35,
+	"getpid":        39,
+	"exit":          60,
+	"futex":         202,
+	"gettid":        186,
+	"clock_gettime": 228,
+	"exit_group":    231,
+	"tgkill":        234,
+	"ppoll":         271,
+}
+
+// DefaultJudgeProfile is the allow-list used for the judge sandbox. It is
+// deliberately the same as DefaultPlayerProfile today, but kept separate
+// so judges that need extra syscalls (e.g. for scoring I/O) have somewhere
+// to grow without loosening the player profile.
+func DefaultJudgeProfile() Profile {
+	return defaultProfile()
+}
+
+// DefaultPlayerProfile is the allow-list applied to contestant binaries.
+func DefaultPlayerProfile() Profile {
+	return defaultProfile()
+}
+
+func defaultProfile() Profile {
+	names := make([]string, 0, len(syscallNumbers))
+	for name := range syscallNumbers {
+		names = append(names, name)
+	}
+	return Profile{
+		DefaultAction: ActionErrno,
+		Syscalls: []SyscallRule{
+			{Names: names, Action: ActionAllow},
+		},
+	}
+}
+
+// LoadProfile reads an OCI runtime-spec-compatible linux.seccomp JSON file
+// from path.
+func LoadProfile(path string) (Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Profile{}, fmt.Errorf("seccomp: read profile %s: %w", path, err)
+	}
+	var p Profile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return Profile{}, fmt.Errorf("seccomp: parse profile %s: %w", path, err)
+	}
+	if p.DefaultAction == "" {
+		p.DefaultAction = ActionErrno
+	}
+	return p, nil
+}
+
+// sockFilter mirrors struct sock_filter from linux/filter.h.
+type sockFilter struct {
+	Code uint16
+	Jt   uint8
+	Jf   uint8
+	K    uint32
+}
+
+// sockFprog mirrors struct sock_fprog from linux/filter.h. The 6-byte gap
+// keeps Filter 8-byte aligned, matching the kernel's amd64 layout.
+type sockFprog struct {
+	Len    uint16
+	_      [6]byte
+	Filter *sockFilter
+}
+
+func actionToRet(a Action, errno uint32) uint32 {
+	switch a {
+	case ActionAllow:
+		return retAllow
+	case ActionKill:
+		return retKillProcess
+	case ActionErrno:
+		if errno == 0 {
+			errno = uint32(syscall.EPERM)
+		}
+		return retErrno | (errno & 0xffff)
+	default:
+		return retErrno | uint32(syscall.EPERM)
+	}
+}
+
+// compile translates a Profile into a cBPF program operating on
+// struct seccomp_data (nr at offset 0, arch at offset 4).
+func compile(p Profile) []sockFilter {
+	prog := []sockFilter{
+		// Validate the architecture first; anything else is killed so a
+		// 32-bit syscall can't sneak past the x86-64 syscall-number checks.
+		{bpfLd | bpfW | bpfAbs, 0, 0, 4},
+		{bpfJmp | bpfJeq | bpfK, 1, 0, auditArchX86_64},
+		{bpfRet | bpfK, 0, 0, retKillProcess},
+		{bpfLd | bpfW | bpfAbs, 0, 0, 0},
+	}
+
+	for _, rule := range p.Syscalls {
+		ret := actionToRet(rule.Action, rule.Errno)
+		for _, name := range rule.Names {
+			nr, ok := syscallNumbers[name]
+			if !ok {
+				continue
+			}
+			prog = append(prog,
+				sockFilter{bpfJmp | bpfJeq | bpfK, 0, 1, nr},
+				sockFilter{bpfRet | bpfK, 0, 0, ret},
+			)
+		}
+	}
+
+	prog = append(prog, sockFilter{bpfRet | bpfK, 0, 0, actionToRet(p.DefaultAction, 0)})
+	return prog
+}
+
+// Apply installs PR_SET_NO_NEW_PRIVS and then loads the compiled profile
+// as a seccomp-bpf filter on the calling thread. It must run after the
+// process has finished any operation seccomp might block (pivot_root,
+// capability drop, etc.) and right before exec.
+func Apply(p Profile) error {
+	if _, _, errno := syscall.Syscall6(sysPrctl, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("seccomp: prctl(PR_SET_NO_NEW_PRIVS): %w", errno)
+	}
+
+	prog := compile(p)
+	fprog := sockFprog{
+		Len:    uint16(len(prog)),
+		Filter: &prog[0],
+	}
+	if _, _, errno := syscall.Syscall(sysSeccomp, secCompSetModeFilter, secCompFilterFlagTSync, uintptr(unsafe.Pointer(&fprog))); errno != 0 {
+		return fmt.Errorf("seccomp: seccomp(SECCOMP_SET_MODE_FILTER): %w", errno)
+	}
+	return nil
+}
+
+// ViolationReason inspects a wait status for the SIGSYS a killed seccomp
+// filter raises and returns the "syscall_violation" Reason string the
+// controller reports, or "" if the process was not killed by seccomp.
+//
+// This intentionally does not carry the offending syscall's name: a wait
+// status alone never exposes it (the kernel only attaches it to siginfo,
+// and the controller's (*exec.Cmd).Wait reaps the child through Wait4, which doesn't deliver
+// siginfo), and resolving it properly needs a ptrace tracer attached for
+// the sandbox's whole lifetime — a bigger change to how the controller
+// waits on judge/player than this package should take on by itself. Do
+// not reformat this to "syscall_violation:<name>" without actually wiring
+// that tracer; a hardcoded placeholder name is worse than none.
+func ViolationReason(ws syscall.WaitStatus) string {
+	if ws.Signaled() && ws.Signal() == syscall.SIGSYS {
+		return "syscall_violation"
+	}
+	return ""
+}
@@ -0,0 +1,89 @@
+package seccomp
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestActionToRet(t *testing.T) {
+	cases := []struct {
+		name  string
+		a     Action
+		errno uint32
+		want  uint32
+	}{
+		{"allow", ActionAllow, 0, retAllow},
+		{"kill", ActionKill, 0, retKillProcess},
+		{"errno default", ActionErrno, 0, retErrno | uint32(syscall.EPERM)},
+		{"errno explicit", ActionErrno, uint32(syscall.ENOSYS), retErrno | uint32(syscall.ENOSYS)},
+		{"unknown action", Action("bogus"), 0, retErrno | uint32(syscall.EPERM)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := actionToRet(c.a, c.errno); got != c.want {
+				t.Errorf("actionToRet(%q, %d) = %#x, want %#x", c.a, c.errno, got, c.want)
+			}
+		})
+	}
+}
+
+// TestCompileAllowsListedSyscallsAndFallsBackToDefault walks the compiled
+// program the way the kernel's BPF interpreter would for a couple of
+// representative syscall numbers, checking that compile wires each
+// SyscallRule and the DefaultAction to the right SECCOMP_RET_*.
+func TestCompileAllowsListedSyscallsAndFallsBackToDefault(t *testing.T) {
+	p := Profile{
+		DefaultAction: ActionErrno,
+		Syscalls: []SyscallRule{
+			{Names: []string{"read", "write"}, Action: ActionAllow},
+			{Names: []string{"ptrace"}, Action: ActionKill}, // not in syscallNumbers: dropped
+		},
+	}
+	prog := compile(p)
+
+	readNR := syscallNumbers["read"]
+	writeNR := syscallNumbers["write"]
+
+	var sawReadAllow, sawWriteAllow bool
+	for i := 0; i+1 < len(prog); i++ {
+		if prog[i].Code != bpfJmp|bpfJeq|bpfK {
+			continue
+		}
+		ret := prog[i+1]
+		if ret.Code != bpfRet|bpfK {
+			continue
+		}
+		switch prog[i].K {
+		case readNR:
+			sawReadAllow = ret.K == retAllow
+		case writeNR:
+			sawWriteAllow = ret.K == retAllow
+		}
+	}
+	if !sawReadAllow {
+		t.Error("compile: read not wired to SECCOMP_RET_ALLOW")
+	}
+	if !sawWriteAllow {
+		t.Error("compile: write not wired to SECCOMP_RET_ALLOW")
+	}
+
+	last := prog[len(prog)-1]
+	wantDefault := actionToRet(p.DefaultAction, 0)
+	if last.Code != bpfRet|bpfK || last.K != wantDefault {
+		t.Errorf("compile: last instruction = %+v, want default ret %#x", last, wantDefault)
+	}
+
+	// ptrace isn't in syscallNumbers, so compile must silently skip it
+	// rather than emit a rule for syscall number 0.
+	for i := 0; i+1 < len(prog)-1; i++ {
+		if prog[i].Code == bpfJmp|bpfJeq|bpfK && prog[i].K == 0 && prog[i+1].K == retKillProcess {
+			t.Error("compile: emitted a rule for an unresolved syscall name")
+		}
+	}
+}
+
+func TestViolationReason(t *testing.T) {
+	if got := ViolationReason(syscall.WaitStatus(0)); got != "" {
+		t.Errorf("ViolationReason(exited) = %q, want \"\"", got)
+	}
+}